@@ -10,10 +10,13 @@ import (
 	"math/big"
 	"sort"
 	"strings"
+
+	"github.com/nomasters/handshake/lib/storage"
 )
 
 const (
-	maxMessageSize = 250000 // ~2 Megabytes
+	maxMessageSize = 250000 // leaves room for padding and encryption within maxPayloadSize
+	maxPayloadSize = storage.MaxIPFSObjectSize
 	defaultChatTTL = 604800 // 7 days in seconds
 )
 
@@ -30,6 +33,8 @@ type ChatLogEntry struct {
 	Received int64    `json:"received,omitempty"`
 	TTL      int64    `json:"ttl,omitempty"`
 	Data     chatData `json:"data"`
+	// Replicas holds the per-node outcome of storing a sent message, if reported by the storage engine
+	Replicas []storage.NodeResult `json:"replicas,omitempty"`
 }
 
 // SortedJSON sorts the chat log and renders it to a JSON representation
@@ -103,7 +108,8 @@ type chatConfig struct {
 }
 
 type chatSettings struct {
-	MaxTTL int64
+	MaxTTL  int64
+	Padding PaddingScheme
 }
 
 // uniqueChatIDsFromPaths takes a lists of paths from and a profile ID and strips out unique ChatID
@@ -156,12 +162,21 @@ func (l lookup) get(key string) []byte {
 	return l[key]
 }
 
+// wipe zeroes all keys in the lookup
+func (l lookup) wipe() {
+	for _, v := range l {
+		zeroBytes(v)
+	}
+}
+
+// popKey takes a lookup hash and removes and returns its one-time key. Callers should zero the key after use.
 func (l *lookup) popKey(key string) []byte {
 	v := l.get(key)
 	delete(*l, key)
 	return v
 }
 
+// popRandom removes and returns a random lookup hash and its one-time key. Callers should zero the key after use.
 func (l *lookup) popRandom() (string, []byte) {
 	k, v := l.getRandom()
 	delete(*l, k)
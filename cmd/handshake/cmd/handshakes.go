@@ -0,0 +1,133 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/nomasters/handshake"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// handshakesCmd represents the handshakes command
+var handshakesCmd = &cobra.Command{
+	Use:   "handshakes",
+	Short: "manage pending handshakes",
+	Long: `manage handshakes that were started with handshake new but haven't created a chat yet.
+For example, to list pending handshakes:
+
+	handshake handshakes list
+
+or to continue one:
+
+	handshake handshakes resume <id>
+	`,
+}
+
+// handshakesListCmd represents the handshakes list command
+var handshakesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list pending handshakes",
+	Long:  `list pending handshakes. Expired handshakes are wiped first.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		password := viper.GetString("Password")
+		session, err := handshake.NewDefaultSession(password)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer session.Close()
+
+		if _, err := session.ExpireHandshakes(); err != nil {
+			log.Fatal(err)
+		}
+		b, err := session.ListHandshakes()
+		if err != nil {
+			log.Fatal(err)
+		}
+		var summaries []handshake.HandshakeSummary
+		if err := json.Unmarshal(b, &summaries); err != nil {
+			log.Fatal(err)
+		}
+		for _, s := range summaries {
+			fmt.Printf("%v\t%v\t%v peers\tstarted %v\n", s.ID, s.Role, s.Peers, time.Unix(s.Created, 0).Format(time.RFC3339))
+		}
+	},
+}
+
+// handshakesResumeCmd represents the handshakes resume command
+var handshakesResumeCmd = &cobra.Command{
+	Use:   "resume <id>",
+	Short: "continue a pending handshake",
+	Long: `continue a pending handshake where it was interrupted. An initiator collects the
+remaining joiner codes, up to --joiners.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		password := viper.GetString("Password")
+		session, err := handshake.NewDefaultSession(password)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer session.Close()
+
+		if err := session.ResumeHandshake(args[0]); err != nil {
+			log.Fatal(err)
+		}
+		summary, err := session.GetHandshakeSummary()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if summary.Role == "initiator" {
+			initiateHandshake(session, password, joinerCount)
+		} else {
+			joinHandshake(session, password)
+		}
+		fmt.Println("congrats. new chat successfully created.")
+	},
+}
+
+// handshakesCancelCmd represents the handshakes cancel command
+var handshakesCancelCmd = &cobra.Command{
+	Use:   "cancel <id>",
+	Short: "cancel a pending handshake",
+	Long:  `cancel a pending handshake and wipe its stored state.`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		password := viper.GetString("Password")
+		session, err := handshake.NewDefaultSession(password)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer session.Close()
+
+		if err := session.CancelHandshake(args[0]); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("handshake successfully cancelled.")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(handshakesCmd)
+	handshakesCmd.AddCommand(handshakesListCmd)
+	handshakesCmd.AddCommand(handshakesResumeCmd)
+	handshakesCmd.AddCommand(handshakesCancelCmd)
+	handshakesResumeCmd.Flags().IntVar(&joinerCount, "joiners", 1, "number of joiners the initiator collects codes from before sharing the chat")
+	handshakesResumeCmd.Flags().BoolVar(&showQRCodes, "qr", false, "show codes as QR codes in the terminal, split into frames that can be scanned in any order")
+	handshakesResumeCmd.Flags().StringVar(&qrCodeDir, "qr-dir", "", "directory to save codes to as QR code PNG frames")
+}
@@ -23,6 +23,12 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var (
+	kdfTime    uint32
+	kdfMemory  uint32
+	kdfThreads uint8
+)
+
 // initCmd represents the init command
 var initCmd = &cobra.Command{
 	Use:   "init",
@@ -35,7 +41,11 @@ This application is a tool to generate the needed files
 to quickly create a Cobra application.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		password := hex.EncodeToString(genRandBytes(16))
-		if err := handshake.NewGenesisProfile(password); err != nil {
+		params := handshake.DefaultKDFParams
+		params.Time = kdfTime
+		params.Memory = kdfMemory
+		params.Threads = kdfThreads
+		if err := handshake.NewGenesisProfileWithKDF(password, params); err != nil {
 			log.Fatal(err)
 		}
 		config := Config{
@@ -51,6 +61,9 @@ to quickly create a Cobra application.`,
 
 func init() {
 	rootCmd.AddCommand(initCmd)
+	initCmd.Flags().Uint32Var(&kdfTime, "kdf-time", handshake.DefaultKDFParams.Time, "argon2 passes used to derive the profile key")
+	initCmd.Flags().Uint32Var(&kdfMemory, "kdf-memory", handshake.DefaultKDFParams.Memory, "argon2 memory in KiB used to derive the profile key")
+	initCmd.Flags().Uint8Var(&kdfThreads, "kdf-threads", handshake.DefaultKDFParams.Threads, "argon2 threads used to derive the profile key")
 	// Here you will define your flags and configuration settings.
 
 	// Cobra supports Persistent Flags which will work for this command
@@ -18,8 +18,10 @@ import (
 	"bufio"
 	"encoding/hex"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/nomasters/handshake"
@@ -27,6 +29,14 @@ import (
 	"github.com/spf13/viper"
 )
 
+var (
+	cipherName  string
+	joinerCount int
+	showQRCodes bool
+	qrCodeDir   string
+	usePIN      bool
+)
+
 // newHandshakeCmd represents the newHandshake command
 var newCmd = &cobra.Command{
 	Use:   "new",
@@ -49,91 +59,194 @@ to quickly create a Cobra application.`,
 			log.Fatal("invalid arg, must be joiner or initiator")
 		}
 
+		cipherType, err := handshake.ParseCipherType(cipherName)
+		if err != nil {
+			log.Fatal(err)
+		}
+
 		switch args[0] {
 		case "joiner":
-			session.NewPeerWithDefaults()
-			share, err := session.ShareHandshakePosition()
-			if err != nil {
-				log.Fatal(err)
-			}
-			shareHex := hex.EncodeToString(share)
-			fmt.Printf(`share this code with the initiator:
-	%v
-	
-and add the initiator code below.`, shareHex)
-			fmt.Print("Enter the initiator code: ")
-			reader := bufio.NewReader(os.Stdin)
-			hexText, err := reader.ReadString('\n')
-			if err != nil {
-				log.Fatal(err)
-			}
-			hexText = strings.TrimSpace(hexText)
-			initiatorShare, err := hex.DecodeString(hexText)
-			if err != nil {
+			if err := session.NewPeerWithCipher(cipherType); err != nil {
 				log.Fatal(err)
 			}
-			if _, err := session.AddPeerToHandshake(initiatorShare); err != nil {
-				log.Fatal(err)
+			printHandshakeID(session)
+			joinHandshake(session, password)
+		case "initiator":
+			if joinerCount < 1 {
+				log.Fatal("there must be at least one joiner")
 			}
-			id, err := session.NewChat()
-			if err != nil {
+			if err := session.NewInitiatorWithCipher(cipherType); err != nil {
 				log.Fatal(err)
 			}
-			config := Config{
-				Password: password,
-				ChatID:   id,
-			}
-			config.Save()
+			printHandshakeID(session)
+			initiateHandshake(session, password, joinerCount)
+		default:
+			log.Fatal("invalid arg, must be joiner or initiator")
+		}
+		fmt.Println("congrats. new chat successfully created.")
+	},
+}
 
-		case "initiator":
-			session.NewInitiatorWithDefaults()
-			reader := bufio.NewReader(os.Stdin)
-			fmt.Print("Enter the joiner code: ")
-			hexText, err := reader.ReadString('\n')
-			if err != nil {
-				log.Fatal(err)
-			}
-			hexText = strings.TrimSpace(hexText)
-			joinerShare, err := hex.DecodeString(hexText)
+// printHandshakeID prints the ID of the active handshake, so that it can be resumed if interrupted
+func printHandshakeID(session *handshake.Session) {
+	summary, err := session.GetHandshakeSummary()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("handshake %v started. if interrupted, continue it with: handshake handshakes resume %v\n", summary.ID, summary.ID)
+}
+
+// joinHandshake shares the position of the active handshake with the initiator, adds the initiator code, and
+// creates the chat
+func joinHandshake(session *handshake.Session, password string) {
+	reader := bufio.NewReader(os.Stdin)
+	if usePIN {
+		fmt.Print("Enter the PIN read aloud by the initiator: ")
+		pin, err := reader.ReadString('\n')
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := session.SetHandshakePIN(pin); err != nil {
+			log.Fatal(err)
+		}
+	}
+	share, err := session.ShareHandshakePosition()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("share this code with the initiator:")
+	printShare(share, "position")
+	fmt.Println("and add the initiator code below.")
+	fmt.Print("Enter the initiator code: ")
+	initiatorShare, err := readCode(reader)
+	if err != nil {
+		log.Fatal(err)
+	}
+	received, err := session.AddPeersToHandshake(initiatorShare)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !received {
+		log.Fatal("the initiator code is missing peers")
+	}
+	saveNewChat(session, password)
+}
+
+// initiateHandshake collects joiner codes for the active handshake until it holds the initiator and joiners,
+// shares every config with the joiners, and creates the chat
+func initiateHandshake(session *handshake.Session, password string, joiners int) {
+	if usePIN {
+		pin, err := session.NewHandshakePIN()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("read this PIN aloud to every joiner, separately from the codes: %v\n", pin)
+	}
+	reader := bufio.NewReader(os.Stdin)
+	for i := session.GetHandshakePeerTotal(); i <= joiners; i++ {
+		fmt.Printf("Enter the code for joiner %v of %v: ", i, joiners)
+		joinerShare, err := readCode(reader)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if _, err := session.AddPeerToHandshake(joinerShare); err != nil {
+			log.Fatal(err)
+		}
+	}
+	share, err := session.GetAllHandshakePeerConfigs()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("share this code with every joiner:")
+	printShare(share, "peers")
+	saveNewChat(session, password)
+}
+
+// saveNewChat creates a chat from the active handshake and saves its ID to the config
+func saveNewChat(session *handshake.Session, password string) {
+	id, err := session.NewChat()
+	if err != nil {
+		log.Fatal(err)
+	}
+	config := Config{
+		Password: password,
+		ChatID:   id,
+	}
+	config.Save()
+}
+
+// printShare prints the text form of a share, or splits it into QR code frames with --qr. With --qr-dir, each
+// frame is also written there as a PNG named after the share.
+func printShare(share []byte, name string) {
+	if !showQRCodes && qrCodeDir == "" {
+		fmt.Printf("\t%v\n\n", handshake.EncodeShareText(share))
+		return
+	}
+	frames, err := handshake.SplitShare(share, handshake.DefaultShareFrameSize)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for i, frame := range frames {
+		fmt.Printf("frame %v of %v:\n", i+1, len(frames))
+		if showQRCodes {
+			qr, err := handshake.QRCodeString(frame)
 			if err != nil {
 				log.Fatal(err)
 			}
-			if _, err := session.AddPeerToHandshake(joinerShare); err != nil {
-				log.Fatal(err)
-			}
-			share, err := session.GetHandshakePeerConfig(1)
+			fmt.Println(qr)
+		}
+		if qrCodeDir != "" {
+			png, err := handshake.QRCodePNG(frame, handshake.DefaultQRCodePNGSize)
 			if err != nil {
 				log.Fatal(err)
 			}
-			shareHex := hex.EncodeToString(share)
-			fmt.Printf(`share this code with the joiner:
-	%v
-	
-and add the initiator code below.`, shareHex)
-			id, err := session.NewChat()
-			if err != nil {
+			path := filepath.Join(qrCodeDir, fmt.Sprintf("%v-%v-of-%v.png", name, i+1, len(frames)))
+			if err := ioutil.WriteFile(path, png, 0600); err != nil {
 				log.Fatal(err)
 			}
-			config := Config{
-				Password: password,
-				ChatID:   id,
-			}
-			config.Save()
-
-		default:
-			log.Fatal("invalid arg, must be joiner or initiator")
+			fmt.Printf("\tsaved to %v\n", path)
 		}
-		fmt.Println("congrats. new chat successfully created.")
-	},
+		fmt.Printf("\t%v\n\n", frame)
+	}
 }
 
-// reader := bufio.NewReader(os.Stdin)
-// fmt.Print("Enter text: ")
-// text, _ := reader.ReadString('\n')
-// fmt.Println(text)
+// readCode reads a code from the reader and returns its bytes and an error. Codes are the text form of a share,
+// or hex encoded json shares from older versions. If the line is a scanned QR code frame, lines are read until
+// every frame of the share has been entered, in any order.
+func readCode(reader *bufio.Reader) ([]byte, error) {
+	text, err := reader.ReadString('\n')
+	if err != nil {
+		return []byte{}, err
+	}
+	if handshake.IsShareText(text) {
+		return []byte(strings.TrimSpace(text)), nil
+	}
+	if !handshake.IsShareFrame(text) {
+		return hex.DecodeString(strings.TrimSpace(text))
+	}
+	frames := handshake.NewShareFrames()
+	for {
+		complete, err := frames.Add(text)
+		if err != nil {
+			return []byte{}, err
+		}
+		if complete {
+			return frames.Bytes()
+		}
+		fmt.Printf("Enter the next frame, still missing %v: ", frames.Missing())
+		if text, err = reader.ReadString('\n'); err != nil {
+			return []byte{}, err
+		}
+	}
+}
 
 func init() {
 	rootCmd.AddCommand(newCmd)
+	newCmd.Flags().IntVar(&joinerCount, "joiners", 1, "number of joiners the initiator collects codes from before sharing the chat")
+	newCmd.Flags().BoolVar(&showQRCodes, "qr", false, "show codes as QR codes in the terminal, split into frames that can be scanned in any order")
+	newCmd.Flags().BoolVar(&usePIN, "pin", false, "wrap codes with a PIN that the initiator reads aloud to every joiner (a captured code can still be opened by guessing the PIN offline, so share codes privately)")
+	newCmd.Flags().StringVar(&qrCodeDir, "qr-dir", "", "directory to save codes to as QR code PNG frames")
+	newCmd.Flags().StringVar(&cipherName, "cipher", "secretbox", "cipher to negotiate for your messages: secretbox, xchacha20poly1305, secretbox-stream or xchacha20poly1305-stream")
 
 	// Here you will define your flags and configuration settings.
 
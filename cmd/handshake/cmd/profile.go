@@ -0,0 +1,178 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/nomasters/handshake"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	rollbackRotation bool
+	duressWipe       bool
+)
+
+// profileCmd represents the profile command
+var profileCmd = &cobra.Command{
+	Use:   "profile",
+	Short: "manage the handshake profile",
+	Long: `manage the handshake profile. For example, to change the profile password:
+
+	handshake profile passwd
+
+or to rotate the profile key:
+
+	handshake profile rotate
+	`,
+}
+
+// profilePasswdCmd represents the profile passwd command
+var profilePasswdCmd = &cobra.Command{
+	Use:   "passwd",
+	Short: "change the profile password",
+	Long: `change the password used to unlock the handshake profile. The new password is
+read from stdin and saved to the config file.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		password := viper.GetString("Password")
+		session, err := handshake.NewDefaultSession(password)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer session.Close()
+
+		fmt.Print("Enter the new password: ")
+		reader := bufio.NewReader(os.Stdin)
+		newPassword, err := reader.ReadString('\n')
+		if err != nil {
+			log.Fatal(err)
+		}
+		newPassword = strings.TrimSpace(newPassword)
+		// the new password is saved before the profile is re-encrypted, so that the password the profile
+		// ends up encrypted under is never only held in memory
+		previous := Config{
+			Password: password,
+			ChatID:   viper.GetString("ChatID"),
+		}
+		config := previous
+		config.Password = newPassword
+		if err := config.Save(); err != nil {
+			log.Fatal(err)
+		}
+		if err := session.ChangePassword(password, newPassword); err != nil {
+			if saveErr := previous.Save(); saveErr != nil {
+				log.Fatalf("%v, and restoring the previous password in the config failed: %v", err, saveErr)
+			}
+			log.Fatal(err)
+		}
+		fmt.Println("profile password successfully changed.")
+	},
+}
+
+// profileRotateCmd represents the profile rotate command
+var profileRotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "rotate the profile key",
+	Long: `rotate the profile key and re-encrypt all chat data stored for the profile. If a
+previous rotation was interrupted, this completes it. Use --rollback to revert an
+interrupted rotation instead.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		password := viper.GetString("Password")
+		session, err := handshake.NewDefaultSession(password)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer session.Close()
+
+		if rollbackRotation {
+			if err := session.RollbackProfileKeyRotation(); err != nil {
+				log.Fatal(err)
+			}
+			fmt.Println("profile key rotation successfully rolled back.")
+			return
+		}
+		if err := session.RotateProfileKey(); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("profile key successfully rotated.")
+	},
+}
+
+// profileDuressCmd represents the profile duress command
+var profileDuressCmd = &cobra.Command{
+	Use:   "duress",
+	Short: "configure a duress password",
+	Long: `configure a duress password that opens a decoy profile instead of this one. The
+duress password is read from stdin. Use --wipe to also delete this profile's chat
+data whenever the duress password is used.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		password := viper.GetString("Password")
+		session, err := handshake.NewDefaultSession(password)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer session.Close()
+
+		fmt.Print("Enter the duress password: ")
+		reader := bufio.NewReader(os.Stdin)
+		duressPassword, err := reader.ReadString('\n')
+		if err != nil {
+			log.Fatal(err)
+		}
+		duressPassword = strings.TrimSpace(duressPassword)
+		if err := session.SetupDuressProfile(password, duressPassword, duressWipe); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("duress password successfully configured.")
+	},
+}
+
+// profileObfuscateCmd represents the profile obfuscate command
+var profileObfuscateCmd = &cobra.Command{
+	Use:   "obfuscate",
+	Short: "obfuscate the storage key names of chat data",
+	Long: `move the profile's chat data to storage keys named with a keyed hash, so that the
+data store doesn't reveal how many chats and peers the profile has. If a previous
+run was interrupted, this completes it.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		password := viper.GetString("Password")
+		session, err := handshake.NewDefaultSession(password)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer session.Close()
+
+		if err := session.EnableKeyObfuscation(); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("storage key names successfully obfuscated.")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(profileCmd)
+	profileCmd.AddCommand(profilePasswdCmd)
+	profileCmd.AddCommand(profileRotateCmd)
+	profileCmd.AddCommand(profileDuressCmd)
+	profileCmd.AddCommand(profileObfuscateCmd)
+	profileRotateCmd.Flags().BoolVar(&rollbackRotation, "rollback", false, "roll back an interrupted profile key rotation")
+	profileDuressCmd.Flags().BoolVar(&duressWipe, "wipe", false, "delete this profile's chat data when the duress password is used")
+}
@@ -0,0 +1,71 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/nomasters/handshake/lib/hashmapserver"
+	"github.com/nomasters/handshake/lib/ipfsserver"
+	"github.com/spf13/cobra"
+)
+
+var serveAddr string
+
+// serveCmd represents the serve command
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Run a local server for self-hosting handshake storage",
+	Long: `Run a local server for self-hosting handshake storage. The servers are in-memory
+and are intended for testing and small private deployments. For example:
+
+	handshake serve hashmap --addr :8080
+	handshake serve ipfs --addr :5001`,
+}
+
+// serveHashmapCmd represents the serve hashmap command
+var serveHashmapCmd = &cobra.Command{
+	Use:   "hashmap",
+	Short: "Run a hashmap compatible rendezvous server",
+	Long: `Run an in-memory hashmap compatible rendezvous server. Submitted payloads must be
+signed, and a payload is only accepted if its timestamp is newer than the stored payload.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Printf("hashmap server listening on %v\n", serveAddr)
+		log.Fatal(http.ListenAndServe(serveAddr, hashmapserver.New()))
+	},
+}
+
+// serveIPFSCmd represents the serve ipfs command
+var serveIPFSCmd = &cobra.Command{
+	Use:   "ipfs",
+	Short: "Run a content addressed IPFS stand-in server",
+	Long: `Run an in-memory, content addressed IPFS stand-in server. It supports both the
+api/v0/add and api/v0/cat RPC endpoints and a writable /ipfs/ gateway, and addresses
+objects by the same CIDv0 hashes an IPFS node would.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Printf("ipfs server listening on %v\n", serveAddr)
+		log.Fatal(http.ListenAndServe(serveAddr, ipfsserver.New()))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+	serveCmd.AddCommand(serveHashmapCmd)
+	serveCmd.AddCommand(serveIPFSCmd)
+
+	serveCmd.PersistentFlags().StringVar(&serveAddr, "addr", ":8080", "address for the server to listen on")
+}
@@ -8,7 +8,6 @@ import (
 	"fmt"
 	"time"
 
-	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/nacl/secretbox"
 )
 
@@ -44,6 +43,12 @@ const (
 const (
 	// SecretBox is a CipherType
 	SecretBox CipherType = iota
+	// XChaCha20Poly1305 is a CipherType
+	XChaCha20Poly1305
+	// SecretBoxStream is a CipherType that uses authenticated stream framing with SecretBox
+	SecretBoxStream
+	// XChaCha20Poly1305Stream is a CipherType that uses authenticated stream framing with XChaCha20Poly1305
+	XChaCha20Poly1305Stream
 )
 
 // Cipher is an interface used for encrypting and decrypting byte slices.
@@ -73,22 +78,27 @@ func genRandBytes(l int) []byte {
 	return b
 }
 
-// genLookups takes a pepper and entropy []byte, a CipherType, and a count and returns a map[string][]byte for lookup hashes
-func genLookups(pepper [64]byte, entropy [96]byte, cipherType CipherType, count int) (lookup, error) {
+// genLookups takes a pepper and entropy []byte, a CipherType, KDFParams, and a count and returns a map[string][]byte
+// for lookup hashes
+func genLookups(pepper [64]byte, entropy [96]byte, cipherType CipherType, params KDFParams, count int) (lookup, error) {
 	lookups := make(map[string][]byte)
 	if count < 1 {
 		return lookups, errors.New("count must be greater than or equal to 1")
 	}
 	p, e1, e2, e3 := pepper[:], entropy[:32], entropy[32:64], entropy[64:]
-	var keyLength int
-	switch cipherType {
-	case SecretBox:
-		keyLength = secretBoxKeyLength
-	default:
-		return lookups, fmt.Errorf("cipher type %v is not implemented for lookup generation", cipherType)
+	engine, err := getCipherEngine(cipherType)
+	if err != nil {
+		return lookups, err
+	}
+	keyLength := engine.KeyLength
+	lookupBytes, err := params.key(p, e2, uint32(count*lookupHashLength))
+	if err != nil {
+		return lookups, err
+	}
+	keyBytes, err := params.key(e1, e3, uint32(count*keyLength))
+	if err != nil {
+		return lookups, err
 	}
-	lookupBytes := argon2.IDKey(p, e2, 1, 64*1024, 4, uint32(count*lookupHashLength))
-	keyBytes := argon2.IDKey(e1, e3, 1, 64*1024, 4, uint32(count*keyLength))
 
 	for i := 1; i < count; i++ {
 		lookupStart := (i - 1) * lookupHashLength
@@ -99,6 +109,7 @@ func genLookups(pepper [64]byte, entropy [96]byte, cipherType CipherType, count
 		v := keyBytes[keyStart:keyEnd]
 		lookups[k] = v
 	}
+	zeroBytes(lookupBytes)
 	return lookups, nil
 }
 
@@ -115,10 +126,9 @@ func genTimeStampNonce(l int) []byte {
 	return nonce
 }
 
-// DeriveKey takes a password and salt and applies a set of fixed parameters
-// to the argon2 IDKey algorithm.
-func deriveKey(pw, salt []byte) []byte {
-	return argon2.IDKey(pw, salt, 1, 64*1024, 4, secretBoxKeyLength)
+// DeriveKey takes a password, salt, and KDFParams and returns a key for the profile cipher and an error
+func deriveKey(pw, salt []byte, params KDFParams) ([]byte, error) {
+	return params.key(pw, salt, secretBoxKeyLength)
 }
 
 // SecretBoxCipher is a struct and method set that conforms to the Cipher interface. This is the primary cipher used
@@ -207,13 +217,18 @@ func (s SecretBoxCipher) Decrypt(data []byte, key []byte) ([]byte, error) {
 
 // GenNonce returns a set of nonce bytes based on the NonceType configured in the struct
 func (s SecretBoxCipher) genNonce() []byte {
-	switch s.Nonce {
+	return genNonceOfType(s.Nonce, secretBoxNonceLength)
+}
+
+// genNonceOfType takes a NonceType and a length and returns a nonce of length l generated for the NonceType
+func genNonceOfType(t NonceType, l int) []byte {
+	switch t {
 	case RandomNonce:
-		return genRandBytes(secretBoxNonceLength)
+		return genRandBytes(l)
 	case TimeSeriesNonce:
-		return genTimeStampNonce(secretBoxNonceLength)
+		return genTimeStampNonce(l)
 	default:
-		return genRandBytes(secretBoxNonceLength)
+		return genRandBytes(l)
 	}
 }
 
@@ -233,26 +248,112 @@ func (s SecretBoxCipher) export() (cipherConfig, error) {
 	}, nil
 }
 
+// cipherEngine holds the key length and constructors for a registered CipherType
+type cipherEngine struct {
+	// Name is the human readable name of the cipher, used by ParseCipherType
+	Name string
+	// KeyLength is the length in bytes of the keys generated for the cipher in lookup tables
+	KeyLength int
+	// New builds a cipher with default settings for use in a new strategy
+	New func() cipher
+	// FromPeer builds a cipher from the peerCipher shared during a handshake
+	FromPeer func(config peerCipher) (cipher, error)
+	// FromConfig builds a cipher from the cipherConfig stored with a chat strategy
+	FromConfig func(config cipherConfig) (cipher, error)
+}
+
+// UnknownCipherError is returned when a cipher is requested for a CipherType that has not been registered
+type UnknownCipherError struct {
+	Type CipherType
+}
+
+// Error returns the error string for an UnknownCipherError
+func (e UnknownCipherError) Error() string {
+	return fmt.Sprintf("unknown cipher type: %v", int(e.Type))
+}
+
+// cipherEngines holds all registered cipher types. Ciphers are registered from init functions with
+// registerCipher and are not modified after initialization.
+var cipherEngines = make(map[CipherType]cipherEngine)
+
+// registerCipher makes a cipher available to genLookups, newCipherFromPeer and newCipherFromConfig under
+// the CipherType. If registerCipher is called twice for the same CipherType it panics.
+func registerCipher(t CipherType, e cipherEngine) {
+	if _, ok := cipherEngines[t]; ok {
+		panic(fmt.Sprintf("handshake: registerCipher called twice for cipher %v", int(t)))
+	}
+	cipherEngines[t] = e
+}
+
+// getCipherEngine returns the cipherEngine for a registered CipherType or an UnknownCipherError
+func getCipherEngine(t CipherType) (cipherEngine, error) {
+	e, ok := cipherEngines[t]
+	if !ok {
+		return cipherEngine{}, UnknownCipherError{Type: t}
+	}
+	return e, nil
+}
+
+// ParseCipherType takes the name of a registered cipher and returns its CipherType and an error
+func ParseCipherType(name string) (CipherType, error) {
+	for t, e := range cipherEngines {
+		if e.Name == name {
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown cipher name: %v", name)
+}
+
+// newCipher takes a CipherType and returns a cipher with default settings and an error
+func newCipher(t CipherType) (c cipher, err error) {
+	engine, err := getCipherEngine(t)
+	if err != nil {
+		return c, err
+	}
+	return engine.New(), nil
+}
+
 func newCipherFromPeer(config peerCipher) (c cipher, err error) {
-	switch config.Type {
-	case SecretBox:
-		return SecretBoxCipher{
-			Nonce:     RandomNonce,
-			ChunkSize: config.ChunkSize,
-		}, nil
-	default:
-		return c, errors.New("cipher not implemented for config import")
+	engine, err := getCipherEngine(config.Type)
+	if err != nil {
+		return c, err
 	}
+	return engine.FromPeer(config)
 }
 
 func newCipherFromConfig(config cipherConfig) (c cipher, err error) {
-	switch config.Type {
-	case SecretBox:
-		return SecretBoxCipher{
-			Nonce:     RandomNonce,
-			ChunkSize: config.ChunkSize,
-		}, nil
-	default:
-		return c, errors.New("cipher not implemented for config import")
+	engine, err := getCipherEngine(config.Type)
+	if err != nil {
+		return c, err
+	}
+	return engine.FromConfig(config)
+}
+
+func init() {
+	registerCipher(SecretBox, cipherEngine{
+		Name:      "secretbox",
+		KeyLength: secretBoxKeyLength,
+		New: func() cipher {
+			return newDefaultSBCipher()
+		},
+		FromPeer: func(config peerCipher) (cipher, error) {
+			return SecretBoxCipher{
+				Nonce:     RandomNonce,
+				ChunkSize: config.ChunkSize,
+			}, nil
+		},
+		FromConfig: func(config cipherConfig) (cipher, error) {
+			return SecretBoxCipher{
+				Nonce:     RandomNonce,
+				ChunkSize: config.ChunkSize,
+			}, nil
+		},
+	})
+}
+
+// zeroBytes overwrites a byte slice with zeros
+func zeroBytes(b []byte) {
+	for i := range b {
+		b[i] = 0
 	}
 }
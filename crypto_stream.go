@@ -0,0 +1,292 @@
+package handshake
+
+import (
+	gocipher "crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// The stream framing is based on the nonce based STREAM construction. A random prefix is generated once
+// per message, and each chunk is sealed with a nonce made up of:
+//
+//	version (1 byte) || prefix (18 bytes) || chunk index (4 bytes, big endian) || final flag (1 byte)
+//
+// The ciphertext is the version and prefix followed by every sealed chunk. Since the version, prefix,
+// chunk index and final flag are all part of each nonce, chunks can't be reordered, dropped, moved between
+// messages, or truncated at a chunk boundary without failing authentication.
+const (
+	// streamVersion1 is the first version of the stream framing
+	streamVersion1 = byte(1)
+	// streamPrefixLength is the length in bytes of the random nonce prefix
+	streamPrefixLength = 18
+	// streamHeaderLength is the length in bytes of the version and nonce prefix
+	streamHeaderLength = 1 + streamPrefixLength
+	// streamNonceLength is the length in bytes of the nonce used for each chunk
+	streamNonceLength = 24
+	// streamTagLength is the length in bytes of the authentication tag on each chunk
+	streamTagLength = 16
+	// streamDefaultChunkSize is the default size of an encrypted chunk of data
+	streamDefaultChunkSize = 16000
+	// streamMaxChunks is the maximum number of chunks that can be indexed in a nonce
+	streamMaxChunks = 1 << 32
+)
+
+// streamSealer is the minimal set of methods needed to seal and open chunks for stream framing. Both
+// SecretBox and XChaCha20Poly1305 use 24 byte nonces and 16 byte authentication tags.
+type streamSealer interface {
+	seal(nonce, chunk []byte) []byte
+	open(nonce, sealed []byte) ([]byte, error)
+}
+
+// SecretBoxStreamCipher is a struct and method set that conforms to the Cipher interface. It seals chunks
+// with SecretBox using the authenticated stream framing.
+type SecretBoxStreamCipher struct {
+	ChunkSize int
+}
+
+// XChaCha20Poly1305StreamCipher is a struct and method set that conforms to the Cipher interface. It seals
+// chunks with XChaCha20Poly1305 using the authenticated stream framing.
+type XChaCha20Poly1305StreamCipher struct {
+	ChunkSize int
+}
+
+// Encrypt takes byte slices for data and a key and returns the stream framed ciphertext output for secretbox
+func (s SecretBoxStreamCipher) Encrypt(data []byte, key []byte) ([]byte, error) {
+	sealer, err := newSecretBoxSealer(key)
+	if err != nil {
+		return []byte{}, err
+	}
+	return streamEncrypt(sealer, streamChunkSize(s.ChunkSize), data)
+}
+
+// Decrypt takes byte slices for data and key and returns the clear text output for stream framed secretbox
+func (s SecretBoxStreamCipher) Decrypt(data []byte, key []byte) ([]byte, error) {
+	sealer, err := newSecretBoxSealer(key)
+	if err != nil {
+		return nil, err
+	}
+	return streamDecrypt(sealer, streamChunkSize(s.ChunkSize), data)
+}
+
+// share is used to export settings shared with a peer
+func (s SecretBoxStreamCipher) share() (peerCipher, error) {
+	return peerCipher{
+		Type:      SecretBoxStream,
+		ChunkSize: streamChunkSize(s.ChunkSize),
+	}, nil
+}
+
+// export returns the secretbox stream type and chunk size as a cipherConfig, for storing the chat strategy
+func (s SecretBoxStreamCipher) export() (cipherConfig, error) {
+	return cipherConfig{
+		Type:      SecretBoxStream,
+		ChunkSize: streamChunkSize(s.ChunkSize),
+	}, nil
+}
+
+// Encrypt takes byte slices for data and a key and returns the stream framed ciphertext output for
+// XChaCha20-Poly1305
+func (x XChaCha20Poly1305StreamCipher) Encrypt(data []byte, key []byte) ([]byte, error) {
+	sealer, err := newXChaChaSealer(key)
+	if err != nil {
+		return []byte{}, err
+	}
+	return streamEncrypt(sealer, streamChunkSize(x.ChunkSize), data)
+}
+
+// Decrypt takes byte slices for data and key and returns the clear text output for stream framed
+// XChaCha20-Poly1305
+func (x XChaCha20Poly1305StreamCipher) Decrypt(data []byte, key []byte) ([]byte, error) {
+	sealer, err := newXChaChaSealer(key)
+	if err != nil {
+		return nil, err
+	}
+	return streamDecrypt(sealer, streamChunkSize(x.ChunkSize), data)
+}
+
+// share is used to export settings shared with a peer
+func (x XChaCha20Poly1305StreamCipher) share() (peerCipher, error) {
+	return peerCipher{
+		Type:      XChaCha20Poly1305Stream,
+		ChunkSize: streamChunkSize(x.ChunkSize),
+	}, nil
+}
+
+// export returns the XChaCha20-Poly1305 stream type and chunk size as a cipherConfig, for storing the chat
+// strategy
+func (x XChaCha20Poly1305StreamCipher) export() (cipherConfig, error) {
+	return cipherConfig{
+		Type:      XChaCha20Poly1305Stream,
+		ChunkSize: streamChunkSize(x.ChunkSize),
+	}, nil
+}
+
+// streamChunkSize returns the chunk size, or the default if none is set
+func streamChunkSize(chunkSize int) int {
+	if chunkSize <= 0 {
+		return streamDefaultChunkSize
+	}
+	return chunkSize
+}
+
+// streamNonce takes a header, chunk index, and final flag and returns the nonce for a chunk
+func streamNonce(header []byte, index uint32, final bool) []byte {
+	nonce := make([]byte, streamNonceLength)
+	copy(nonce, header)
+	binary.BigEndian.PutUint32(nonce[streamHeaderLength:], index)
+	if final {
+		nonce[streamNonceLength-1] = 1
+	}
+	return nonce
+}
+
+// streamEncrypt takes a streamSealer, chunk size, and data and returns the stream framed ciphertext.
+// An empty message is still sealed as a single, empty, final chunk so that it can't be forged by truncation.
+func streamEncrypt(sealer streamSealer, chunkSize int, data []byte) ([]byte, error) {
+	chunkCount := (len(data) + chunkSize - 1) / chunkSize
+	if chunkCount == 0 {
+		chunkCount = 1
+	}
+	if uint64(chunkCount) > streamMaxChunks {
+		return []byte{}, errors.New("data is too large for stream framing")
+	}
+
+	header := make([]byte, streamHeaderLength)
+	header[0] = streamVersion1
+	copy(header[1:], genRandBytes(streamPrefixLength))
+
+	encryptedData := append([]byte{}, header...)
+	for i := 0; i < chunkCount; i++ {
+		start := i * chunkSize
+		end := start + chunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		nonce := streamNonce(header, uint32(i), i == chunkCount-1)
+		encryptedData = append(encryptedData, sealer.seal(nonce, data[start:end])...)
+	}
+	return encryptedData, nil
+}
+
+// streamDecrypt takes a streamSealer, chunk size, and stream framed data and returns the clear text
+func streamDecrypt(sealer streamSealer, chunkSize int, data []byte) ([]byte, error) {
+	if len(data) < streamHeaderLength+streamTagLength {
+		return nil, errors.New("decrypt failed")
+	}
+	if data[0] != streamVersion1 {
+		return nil, fmt.Errorf("unsupported stream version: %v", data[0])
+	}
+	header, body := data[:streamHeaderLength], data[streamHeaderLength:]
+	sealedChunkSize := chunkSize + streamTagLength
+	chunkCount := (len(body) + sealedChunkSize - 1) / sealedChunkSize
+	if uint64(chunkCount) > streamMaxChunks {
+		return nil, errors.New("decrypt failed")
+	}
+
+	var decryptedData []byte
+	for i := 0; i < chunkCount; i++ {
+		start := i * sealedChunkSize
+		end := start + sealedChunkSize
+		if end > len(body) {
+			end = len(body)
+		}
+		nonce := streamNonce(header, uint32(i), i == chunkCount-1)
+		decryptedChunk, err := sealer.open(nonce, body[start:end])
+		if err != nil {
+			return nil, err
+		}
+		decryptedData = append(decryptedData, decryptedChunk...)
+	}
+	return decryptedData, nil
+}
+
+// secretBoxSealer conforms to the streamSealer interface using secretbox
+type secretBoxSealer struct {
+	key [secretBoxKeyLength]byte
+}
+
+func newSecretBoxSealer(key []byte) (secretBoxSealer, error) {
+	var s secretBoxSealer
+	if len(key) != secretBoxKeyLength {
+		return s, errors.New("invalid key length")
+	}
+	copy(s.key[:], key)
+	return s, nil
+}
+
+func (s secretBoxSealer) seal(nonce, chunk []byte) []byte {
+	var n [secretBoxNonceLength]byte
+	copy(n[:], nonce)
+	return secretbox.Seal(nil, chunk, &n, &s.key)
+}
+
+func (s secretBoxSealer) open(nonce, sealed []byte) ([]byte, error) {
+	var n [secretBoxNonceLength]byte
+	copy(n[:], nonce)
+	decrypted, ok := secretbox.Open(nil, sealed, &n, &s.key)
+	if !ok {
+		return nil, errors.New("decrypt failed")
+	}
+	return decrypted, nil
+}
+
+// xChaChaSealer conforms to the streamSealer interface using XChaCha20-Poly1305
+type xChaChaSealer struct {
+	aead gocipher.AEAD
+}
+
+func newXChaChaSealer(key []byte) (xChaChaSealer, error) {
+	if len(key) != chacha20poly1305.KeySize {
+		return xChaChaSealer{}, errors.New("invalid key length")
+	}
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return xChaChaSealer{}, err
+	}
+	return xChaChaSealer{aead: aead}, nil
+}
+
+func (x xChaChaSealer) seal(nonce, chunk []byte) []byte {
+	return x.aead.Seal(nil, nonce, chunk, nil)
+}
+
+func (x xChaChaSealer) open(nonce, sealed []byte) ([]byte, error) {
+	decrypted, err := x.aead.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return nil, errors.New("decrypt failed")
+	}
+	return decrypted, nil
+}
+
+func init() {
+	registerCipher(SecretBoxStream, cipherEngine{
+		Name:      "secretbox-stream",
+		KeyLength: secretBoxKeyLength,
+		New: func() cipher {
+			return SecretBoxStreamCipher{ChunkSize: streamDefaultChunkSize}
+		},
+		FromPeer: func(config peerCipher) (cipher, error) {
+			return SecretBoxStreamCipher{ChunkSize: config.ChunkSize}, nil
+		},
+		FromConfig: func(config cipherConfig) (cipher, error) {
+			return SecretBoxStreamCipher{ChunkSize: config.ChunkSize}, nil
+		},
+	})
+	registerCipher(XChaCha20Poly1305Stream, cipherEngine{
+		Name:      "xchacha20poly1305-stream",
+		KeyLength: chacha20poly1305.KeySize,
+		New: func() cipher {
+			return XChaCha20Poly1305StreamCipher{ChunkSize: streamDefaultChunkSize}
+		},
+		FromPeer: func(config peerCipher) (cipher, error) {
+			return XChaCha20Poly1305StreamCipher{ChunkSize: config.ChunkSize}, nil
+		},
+		FromConfig: func(config cipherConfig) (cipher, error) {
+			return XChaCha20Poly1305StreamCipher{ChunkSize: config.ChunkSize}, nil
+		},
+	})
+}
@@ -12,11 +12,11 @@ func TestGenLookups(t *testing.T) {
 	copy(pepper[:], []byte("maich3zu1theeKahThi0CaechahZ1nei1ahcaitah1Au5quie5bee6PaeW5hie3y"))
 	copy(entropy[:], []byte("aiphaiyu3aem2ko4ni4ohxohca1Iech9ohpie9uo9uij4Fe7hieVaowieh9ahGhiezeeyahZu9eeSahphaxaecaisutu0uij"))
 
-	l1, err := genLookups(pepper, entropy, SecretBox, 100000)
+	l1, err := genLookups(pepper, entropy, SecretBox, DefaultKDFParams, 100000)
 	if err != nil {
 		t.Error(err)
 	}
-	l2, err := genLookups(pepper, entropy, SecretBox, 100000)
+	l2, err := genLookups(pepper, entropy, SecretBox, DefaultKDFParams, 100000)
 	if err != nil {
 		t.Error(err)
 	}
@@ -27,3 +27,142 @@ func TestGenLookups(t *testing.T) {
 		}
 	}
 }
+
+func TestUnknownCipher(t *testing.T) {
+	var pepper [64]byte
+	var entropy [96]byte
+	unknown := CipherType(99)
+
+	if _, err := genLookups(pepper, entropy, unknown, DefaultKDFParams, 10); err == nil {
+		t.Error("expected genLookups to fail for an unknown cipher type")
+	}
+	_, err := newCipherFromPeer(peerCipher{Type: unknown})
+	if _, ok := err.(UnknownCipherError); !ok {
+		t.Errorf("expected UnknownCipherError, got %v", err)
+	}
+	_, err = newCipherFromConfig(cipherConfig{Type: unknown})
+	if _, ok := err.(UnknownCipherError); !ok {
+		t.Errorf("expected UnknownCipherError, got %v", err)
+	}
+}
+
+func TestXChaCha20Poly1305Cipher(t *testing.T) {
+	key := genRandBytes(32)
+	data := genRandBytes(xChaChaDefaultChunkSize*2 + 100)
+	c := newDefaultXChaChaCipher()
+
+	encrypted, err := c.Encrypt(data, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(encrypted) != len(data)+3*xChaChaDecryptionOffset {
+		t.Errorf("unexpected ciphertext length: %v", len(encrypted))
+	}
+	decrypted, err := c.Decrypt(encrypted, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, decrypted) {
+		t.Error("decrypted data does not match")
+	}
+
+	encrypted[len(encrypted)-1] ^= 1
+	if _, err := c.Decrypt(encrypted, key); err == nil {
+		t.Error("expected tampered ciphertext to fail decryption")
+	}
+
+	pc, err := c.share()
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported, err := newCipherFromPeer(pc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := imported.(XChaCha20Poly1305Cipher); !ok {
+		t.Errorf("expected XChaCha20Poly1305Cipher, got %T", imported)
+	}
+
+	var pepper [64]byte
+	var entropy [96]byte
+	lookups, err := genLookups(pepper, entropy, XChaCha20Poly1305, DefaultKDFParams, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range lookups {
+		if _, err := c.Encrypt(data, v); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestStreamCiphers(t *testing.T) {
+	key := genRandBytes(32)
+	chunkSize := 100
+	sealedChunkSize := chunkSize + streamTagLength
+	data := genRandBytes(chunkSize*3 + 10)
+
+	for _, c := range []cipher{SecretBoxStreamCipher{ChunkSize: chunkSize}, XChaCha20Poly1305StreamCipher{ChunkSize: chunkSize}} {
+		encrypted, err := c.Encrypt(data, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decrypted, err := c.Decrypt(encrypted, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, decrypted) {
+			t.Errorf("%T: decrypted data does not match", c)
+		}
+
+		header, body := encrypted[:streamHeaderLength], encrypted[streamHeaderLength:]
+		chunk := func(i int) []byte { return body[i*sealedChunkSize : (i+1)*sealedChunkSize] }
+
+		var reordered []byte
+		reordered = append(reordered, header...)
+		reordered = append(reordered, chunk(1)...)
+		reordered = append(reordered, chunk(0)...)
+		reordered = append(reordered, body[2*sealedChunkSize:]...)
+
+		var dropped []byte
+		dropped = append(dropped, header...)
+		dropped = append(dropped, chunk(0)...)
+		dropped = append(dropped, body[2*sealedChunkSize:]...)
+
+		versioned := append([]byte{}, encrypted...)
+		versioned[0] = streamVersion1 + 1
+
+		tampered := map[string][]byte{
+			"reordered": reordered,
+			"dropped":   dropped,
+			"truncated": encrypted[:streamHeaderLength+2*sealedChunkSize],
+			"versioned": versioned,
+			"empty":     header,
+		}
+		for name, b := range tampered {
+			if _, err := c.Decrypt(b, key); err == nil {
+				t.Errorf("%T: expected %v ciphertext to fail decryption", c, name)
+			}
+		}
+
+		empty, err := c.Encrypt([]byte{}, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decrypted, err := c.Decrypt(empty, key); err != nil || len(decrypted) != 0 {
+			t.Errorf("%T: empty message did not round trip: %v", c, err)
+		}
+
+		pc, err := c.share()
+		if err != nil {
+			t.Fatal(err)
+		}
+		imported, err := newCipherFromPeer(pc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decrypted, err := imported.Decrypt(encrypted, key); err != nil || !bytes.Equal(data, decrypted) {
+			t.Errorf("%T: imported cipher failed to decrypt: %v", c, err)
+		}
+	}
+}
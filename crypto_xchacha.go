@@ -0,0 +1,137 @@
+package handshake
+
+import (
+	"errors"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	// xChaChaDefaultChunkSize is the default size of an encrypted chunk of data
+	xChaChaDefaultChunkSize = 16000
+	// xChaChaTagLength is the length in bytes of the Poly1305 authentication tag
+	xChaChaTagLength = 16
+	// xChaChaDecryptionOffset is the additional offset of bytes needed to offset
+	// for the nonce and authentication bytes
+	xChaChaDecryptionOffset = chacha20poly1305.NonceSizeX + xChaChaTagLength
+)
+
+// XChaCha20Poly1305Cipher is a struct and method set that conforms to the Cipher interface. It uses the same
+// chunked layout as SecretBoxCipher, where each chunk is prefixed with its nonce, but seals chunks with the
+// IETF XChaCha20-Poly1305 AEAD.
+type XChaCha20Poly1305Cipher struct {
+	Nonce     NonceType
+	ChunkSize int
+}
+
+// newDefaultXChaChaCipher returns a RandomNonce based XChaCha20Poly1305Cipher struct that conforms to the
+// Cipher interface
+func newDefaultXChaChaCipher() XChaCha20Poly1305Cipher {
+	return XChaCha20Poly1305Cipher{Nonce: RandomNonce, ChunkSize: xChaChaDefaultChunkSize}
+}
+
+// Encrypt takes byte slices for data and a key and returns the ciphertext output for XChaCha20-Poly1305
+func (x XChaCha20Poly1305Cipher) Encrypt(data []byte, key []byte) ([]byte, error) {
+	var encryptedData []byte
+	chunkSize := x.chunkSize()
+
+	if len(key) != chacha20poly1305.KeySize {
+		return encryptedData, errors.New("invalid key length")
+	}
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return encryptedData, err
+	}
+
+	for i := 0; i < len(data); i = i + chunkSize {
+		var chunk []byte
+		if len(data[i:]) >= chunkSize {
+			chunk = data[i : i+chunkSize]
+		} else {
+			chunk = data[i:]
+		}
+		nonce := genNonceOfType(x.Nonce, chacha20poly1305.NonceSizeX)
+		encryptedChunk := aead.Seal(nonce, nonce, chunk, nil)
+		encryptedData = append(encryptedData, encryptedChunk...)
+	}
+	return encryptedData, nil
+}
+
+// Decrypt takes byte slices for data and key and returns the clear text output for XChaCha20-Poly1305
+func (x XChaCha20Poly1305Cipher) Decrypt(data []byte, key []byte) ([]byte, error) {
+	var decryptedData []byte
+	chunkSize := x.chunkSize() + xChaChaDecryptionOffset
+
+	if len(key) != chacha20poly1305.KeySize {
+		return decryptedData, errors.New("invalid key length")
+	}
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return decryptedData, err
+	}
+
+	for i := 0; i < len(data); i = i + chunkSize {
+		var chunk []byte
+		if len(data[i:]) >= chunkSize {
+			chunk = data[i : i+chunkSize]
+		} else {
+			chunk = data[i:]
+		}
+		if len(chunk) < xChaChaDecryptionOffset {
+			return nil, errors.New("decrypt failed")
+		}
+		nonce := chunk[:chacha20poly1305.NonceSizeX]
+		decryptedChunk, err := aead.Open(nil, nonce, chunk[chacha20poly1305.NonceSizeX:], nil)
+		if err != nil {
+			return nil, errors.New("decrypt failed")
+		}
+		decryptedData = append(decryptedData, decryptedChunk...)
+	}
+	return decryptedData, nil
+}
+
+// chunkSize returns the configured ChunkSize, or the default if none is set
+func (x XChaCha20Poly1305Cipher) chunkSize() int {
+	if x.ChunkSize <= 0 {
+		return xChaChaDefaultChunkSize
+	}
+	return x.ChunkSize
+}
+
+// share is used to export settings shared with a peer
+func (x XChaCha20Poly1305Cipher) share() (peerCipher, error) {
+	return peerCipher{
+		Type:      XChaCha20Poly1305,
+		ChunkSize: x.chunkSize(),
+	}, nil
+}
+
+// export returns the XChaCha20-Poly1305 type and chunk size as a cipherConfig, for storing the chat strategy
+func (x XChaCha20Poly1305Cipher) export() (cipherConfig, error) {
+	return cipherConfig{
+		Type:      XChaCha20Poly1305,
+		ChunkSize: x.chunkSize(),
+	}, nil
+}
+
+func init() {
+	registerCipher(XChaCha20Poly1305, cipherEngine{
+		Name:      "xchacha20poly1305",
+		KeyLength: chacha20poly1305.KeySize,
+		New: func() cipher {
+			return newDefaultXChaChaCipher()
+		},
+		FromPeer: func(config peerCipher) (cipher, error) {
+			return XChaCha20Poly1305Cipher{
+				Nonce:     RandomNonce,
+				ChunkSize: config.ChunkSize,
+			}, nil
+		},
+		FromConfig: func(config cipherConfig) (cipher, error) {
+			return XChaCha20Poly1305Cipher{
+				Nonce:     RandomNonce,
+				ChunkSize: config.ChunkSize,
+			}, nil
+		},
+	})
+}
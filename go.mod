@@ -6,6 +6,7 @@ require (
 	github.com/mattn/go-isatty v0.0.7 // indirect
 	github.com/multiformats/go-multihash v0.0.1
 	github.com/nomasters/hashmap v0.0.4
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
 	github.com/spf13/cobra v0.0.3
 	github.com/spf13/viper v1.3.2
 	go.etcd.io/bbolt v1.3.2
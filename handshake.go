@@ -4,10 +4,13 @@ import (
 	"bytes"
 	"crypto/rand"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"math/big"
 	"strings"
+	"time"
 
 	"golang.org/x/crypto/blake2b"
 )
@@ -26,11 +29,15 @@ const (
 )
 
 type handshake struct {
+	ID          string
+	Created     int64
 	Role        role
 	Negotiators []negotiator
 	Config      handshakeConfig
 	Position    negotiator
 	PeerTotal   int
+	// PIN is set when shares of the handshake are wrapped with a key derived from a PIN
+	PIN []byte
 }
 
 type handshakeConfig struct {
@@ -53,6 +60,9 @@ type peerConfig struct {
 }
 
 // AddPeer takes a peerConfig and adds it to a handshake negotiator slice. It checks for unique Entropy bytes.
+// A peer takes the configs shared by the initiator, which carry their sort order. The peer's own config is
+// matched to its Position by Entropy, and once every other config is received, the Position takes the remaining
+// sort order, so the initiator may share either every config or only the configs of the other peers.
 func (h *handshake) AddPeer(config peerConfig) error {
 	if h.Role == peer {
 		if config.Item == 0 {
@@ -61,6 +71,9 @@ func (h *handshake) AddPeer(config peerConfig) error {
 		if config.Item > config.TotalItems {
 			return errors.New("sort oder id is greater than total size")
 		}
+		if h.PeerTotal != 0 && h.PeerTotal != config.TotalItems {
+			return fmt.Errorf("expected %v total items but got %v", h.PeerTotal, config.TotalItems)
+		}
 		h.PeerTotal = config.TotalItems
 	}
 
@@ -68,19 +81,22 @@ func (h *handshake) AddPeer(config peerConfig) error {
 	if err != nil {
 		return err
 	}
+	if h.Role == peer && bytes.Equal(n.Entropy, h.Position.Entropy) {
+		return h.addPosition(config.Item)
+	}
 	// ensure that the same peer isn't added twice
 	for _, negotiator := range h.Negotiators {
 		if bytes.Equal(negotiator.Entropy, n.Entropy) {
 			return errors.New("duplicate detected, peer must be unique")
 		}
+		if h.Role == peer && negotiator.SortOrder == n.SortOrder {
+			return errors.New("duplicate sort order detected")
+		}
 	}
 	h.Negotiators = append(h.Negotiators, n)
 
-	if h.Role == peer {
-		if config.Item == 1 && config.TotalItems == 2 {
-			h.Negotiators = append(h.Negotiators, h.Position)
-			h.Negotiators[1].SortOrder = 2
-		}
+	if h.Role == peer && !h.hasPosition() && len(h.Negotiators) == h.PeerTotal-1 {
+		return h.addPosition(h.missingSortOrder())
 	}
 
 	if h.Role == initiator {
@@ -89,6 +105,50 @@ func (h *handshake) AddPeer(config peerConfig) error {
 	return nil
 }
 
+// addPosition takes a sort order and adds the Position of a peer to the negotiators with that sort order. If
+// the Position was already added, the sort order must match.
+func (h *handshake) addPosition(sortOrder int) error {
+	for _, n := range h.Negotiators {
+		if bytes.Equal(n.Entropy, h.Position.Entropy) {
+			if n.SortOrder != sortOrder {
+				return errors.New("position sort order mismatch")
+			}
+			return nil
+		}
+		if n.SortOrder == sortOrder {
+			return errors.New("duplicate sort order detected")
+		}
+	}
+	position := h.Position
+	position.SortOrder = sortOrder
+	h.Negotiators = append(h.Negotiators, position)
+	return nil
+}
+
+// hasPosition returns true if the Position has been added to the negotiators
+func (h *handshake) hasPosition() bool {
+	for _, n := range h.Negotiators {
+		if bytes.Equal(n.Entropy, h.Position.Entropy) {
+			return true
+		}
+	}
+	return false
+}
+
+// missingSortOrder returns the lowest sort order not yet taken by a negotiator, or 0 if all are taken
+func (h *handshake) missingSortOrder() int {
+	taken := make(map[int]bool)
+	for _, n := range h.Negotiators {
+		taken[n.SortOrder] = true
+	}
+	for i := 1; i <= h.PeerTotal; i++ {
+		if !taken[i] {
+			return i
+		}
+	}
+	return 0
+}
+
 // Share returns JSON encoded bytes of a peerConfig and an error
 func (n negotiator) Share() (b []byte, err error) {
 	config, err := n.PeerConfig()
@@ -194,6 +254,17 @@ func generatePepper(negotiators []negotiator) []byte {
 	return h[:]
 }
 
+// deriveID takes a pepper and a label and returns a hex encoded ID derived from them and an error. Every
+// participant in a handshake derives the same pepper, so they all derive the same chat and peer IDs.
+func deriveID(pepper []byte, label string) (string, error) {
+	h, err := blake2b.New(chatIDLength, pepper)
+	if err != nil {
+		return "", err
+	}
+	h.Write([]byte(label))
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
 // GetPeerTotal returns the total count of peers expected for handshake exchange.
 // If no peers are present, it returns 0, meaning peer count is invalid
 // If 1 peer is present, it returns 1, for simplified exchange between two parties
@@ -222,6 +293,8 @@ func newHandshake(strategy strategy, opts handshakeOptions) *handshake {
 	}
 
 	h := handshake{
+		ID:       hex.EncodeToString(genRandBytes(chatIDLength)),
+		Created:  time.Now().Unix(),
 		Role:     opts.Role,
 		Position: position,
 		Config: handshakeConfig{
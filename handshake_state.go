@@ -0,0 +1,342 @@
+package handshake
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// handshakeKeyPrefix is the prefix used for the keys of pending handshakes
+	handshakeKeyPrefix = "handshakes/"
+	// DefaultHandshakeTTL is the number of seconds after which a pending handshake expires
+	DefaultHandshakeTTL = 60 * 60 // 1 hour in seconds
+)
+
+// ErrHandshakeExpired is returned when resuming a pending handshake older than DefaultHandshakeTTL
+var ErrHandshakeExpired = errors.New("handshake expired")
+
+// a handshakeState allows safe encoding of a handshake
+type handshakeState struct {
+	ID          string
+	Created     int64
+	Role        role
+	Negotiators []negotiatorConfig
+	Config      handshakeConfig
+	Position    negotiatorConfig
+	PeerTotal   int
+	PIN         []byte
+}
+
+// a negotiatorConfig allows safe encoding of a negotiator
+type negotiatorConfig struct {
+	Entropy   []byte
+	Alias     string
+	Strategy  strategyConfig
+	SortOrder int
+}
+
+// HandshakeSummary describes a pending handshake
+type HandshakeSummary struct {
+	ID        string `json:"id"`
+	Role      string `json:"role"`
+	Created   int64  `json:"created"`
+	Peers     int    `json:"peers"`
+	PeerTotal int    `json:"peer_total,omitempty"`
+}
+
+// String returns the name of the role
+func (r role) String() string {
+	switch r {
+	case initiator:
+		return "initiator"
+	case peer:
+		return "peer"
+	default:
+		return fmt.Sprintf("role(%d)", int(r))
+	}
+}
+
+// Config returns a storage-safe negotiatorConfig and an error
+func (n negotiator) Config() (negotiatorConfig, error) {
+	config := negotiatorConfig{
+		Entropy:   n.Entropy,
+		Alias:     n.Alias,
+		SortOrder: n.SortOrder,
+	}
+	s, err := n.Strategy.Export()
+	config.Strategy = s
+	return config, err
+}
+
+// Negotiator converts a negotiatorConfig into a negotiator
+func (config negotiatorConfig) Negotiator() (negotiator, error) {
+	s, err := strategyFromConfig(config.Strategy)
+	if err != nil {
+		return negotiator{}, err
+	}
+	return negotiator{
+		Entropy:   config.Entropy,
+		Alias:     config.Alias,
+		Strategy:  s,
+		SortOrder: config.SortOrder,
+	}, nil
+}
+
+// State returns a storage-safe handshakeState and an error
+func (h *handshake) State() (handshakeState, error) {
+	state := handshakeState{
+		ID:        h.ID,
+		Created:   h.Created,
+		Role:      h.Role,
+		Config:    h.Config,
+		PeerTotal: h.PeerTotal,
+		PIN:       h.PIN,
+	}
+	position, err := h.Position.Config()
+	if err != nil {
+		return handshakeState{}, err
+	}
+	state.Position = position
+	for _, n := range h.Negotiators {
+		config, err := n.Config()
+		if err != nil {
+			return handshakeState{}, err
+		}
+		state.Negotiators = append(state.Negotiators, config)
+	}
+	return state, nil
+}
+
+// Handshake converts a handshakeState into a handshake
+func (state handshakeState) Handshake() (*handshake, error) {
+	h := handshake{
+		ID:        state.ID,
+		Created:   state.Created,
+		Role:      state.Role,
+		Config:    state.Config,
+		PeerTotal: state.PeerTotal,
+		PIN:       state.PIN,
+	}
+	position, err := state.Position.Negotiator()
+	if err != nil {
+		return nil, err
+	}
+	h.Position = position
+	for _, config := range state.Negotiators {
+		n, err := config.Negotiator()
+		if err != nil {
+			return nil, err
+		}
+		h.Negotiators = append(h.Negotiators, n)
+	}
+	return &h, nil
+}
+
+// Summary returns a HandshakeSummary of the handshake
+func (h *handshake) Summary() HandshakeSummary {
+	return HandshakeSummary{
+		ID:        h.ID,
+		Role:      h.Role.String(),
+		Created:   h.Created,
+		Peers:     len(h.Negotiators),
+		PeerTotal: h.PeerTotal,
+	}
+}
+
+// expired returns true if the handshake is older than DefaultHandshakeTTL
+func (h *handshake) expired() bool {
+	return time.Now().Unix() >= h.Created+DefaultHandshakeTTL
+}
+
+// wipe zeroes the entropy and PIN held by the handshake
+func (h *handshake) wipe() {
+	zeroBytes(h.PIN)
+	zeroBytes(h.Position.Entropy)
+	for _, n := range h.Negotiators {
+		zeroBytes(n.Entropy)
+	}
+}
+
+// takes gob encoded byte slice and returns a handshake and error
+func newHandshakeFromGob(b []byte) (*handshake, error) {
+	var state handshakeState
+	var buffer bytes.Buffer
+	buffer.Write(b)
+	if err := gob.NewDecoder(&buffer).Decode(&state); err != nil {
+		return nil, err
+	}
+	return state.Handshake()
+}
+
+// handshakePath takes a handshake ID and returns the path it is stored under for the session profile
+func (s *Session) handshakePath(id string) string {
+	return fmt.Sprintf("%v%v/%v", handshakeKeyPrefix, id, s.profile.ID)
+}
+
+// handshakeIDsFromPaths takes a list of paths and a profile ID and returns the IDs of the paths that match
+// handshakes/<id>/<profileID> exactly
+func handshakeIDsFromPaths(list []string, profileID string) (ids []string) {
+	for _, l := range list {
+		s := strings.Split(l, "/")
+		if len(s) == 3 && s[0]+"/" == handshakeKeyPrefix && s[1] != "" && s[2] == profileID {
+			ids = append(ids, s[1])
+		}
+	}
+	return
+}
+
+func (s *Session) getHandshake(id string) (*handshake, error) {
+	b, err := s.get(s.handshakePath(id))
+	if err != nil {
+		return nil, err
+	}
+	defer zeroBytes(b)
+	return newHandshakeFromGob(b)
+}
+
+func (s *Session) setHandshake(h *handshake) error {
+	state, err := h.State()
+	if err != nil {
+		return err
+	}
+	b, err := encodeGob(state)
+	if err != nil {
+		return err
+	}
+	defer zeroBytes(b)
+	_, err = s.set(s.handshakePath(h.ID), b)
+	return err
+}
+
+// startHandshake takes a handshake, stores it, and sets it as the activeHandshake
+func (s *Session) startHandshake(h *handshake) error {
+	if err := s.setHandshake(h); err != nil {
+		return err
+	}
+	s.activeHandshake = h
+	return nil
+}
+
+// saveActiveHandshake stores the activeHandshake. If err is not nil, the activeHandshake is instead reloaded
+// from storage, so that a partially applied change is discarded, and err is returned.
+func (s *Session) saveActiveHandshake(err error) error {
+	if err != nil {
+		if h, loadErr := s.getHandshake(s.activeHandshake.ID); loadErr == nil {
+			s.activeHandshake = h
+		}
+		return err
+	}
+	return s.setHandshake(s.activeHandshake)
+}
+
+// pendingHandshake returns an error if there is no activeHandshake
+func (s *Session) pendingHandshake() error {
+	if s.activeHandshake == nil || s.activeHandshake.ID == "" {
+		return errors.New("no handshake in progress")
+	}
+	return nil
+}
+
+// GetHandshakeSummary returns the HandshakeSummary of the active handshake and an error
+func (s *Session) GetHandshakeSummary() (HandshakeSummary, error) {
+	if err := s.active(); err != nil {
+		return HandshakeSummary{}, err
+	}
+	if err := s.pendingHandshake(); err != nil {
+		return HandshakeSummary{}, err
+	}
+	return s.activeHandshake.Summary(), nil
+}
+
+// ListHandshakes returns a json encoded list of HandshakeSummary for the pending handshakes of the profile,
+// including expired ones, and an error
+func (s *Session) ListHandshakes() ([]byte, error) {
+	if err := s.active(); err != nil {
+		return []byte{}, err
+	}
+	summaries := []HandshakeSummary{}
+	list, err := s.list(handshakeKeyPrefix)
+	if err != nil {
+		return []byte{}, err
+	}
+	for _, id := range handshakeIDsFromPaths(list, s.profile.ID) {
+		h, err := s.getHandshake(id)
+		if err != nil {
+			return []byte{}, err
+		}
+		summaries = append(summaries, h.Summary())
+		h.wipe()
+	}
+	return json.Marshal(summaries)
+}
+
+// ResumeHandshake takes the ID of a pending handshake and sets it as the active handshake. Expired handshakes
+// are cancelled and ErrHandshakeExpired is returned.
+func (s *Session) ResumeHandshake(id string) error {
+	if err := s.active(); err != nil {
+		return err
+	}
+	h, err := s.getHandshake(id)
+	if err != nil {
+		return err
+	}
+	if h.expired() {
+		h.wipe()
+		if err := s.wipe(s.handshakePath(id)); err != nil {
+			return err
+		}
+		return ErrHandshakeExpired
+	}
+	s.activeHandshake = h
+	return nil
+}
+
+// CancelHandshake takes the ID of a pending handshake and wipes it
+func (s *Session) CancelHandshake(id string) error {
+	if err := s.active(); err != nil {
+		return err
+	}
+	return s.cancelHandshake(id)
+}
+
+func (s *Session) cancelHandshake(id string) error {
+	if s.activeHandshake != nil && s.activeHandshake.ID == id {
+		s.activeHandshake.wipe()
+		s.activeHandshake = &handshake{}
+	}
+	return s.wipe(s.handshakePath(id))
+}
+
+// ExpireHandshakes wipes all pending handshakes of the profile older than DefaultHandshakeTTL and returns the
+// number wiped and an error
+func (s *Session) ExpireHandshakes() (int, error) {
+	if err := s.active(); err != nil {
+		return 0, err
+	}
+	list, err := s.list(handshakeKeyPrefix)
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	for _, id := range handshakeIDsFromPaths(list, s.profile.ID) {
+		h, err := s.getHandshake(id)
+		if err != nil {
+			return count, err
+		}
+		expired := h.expired()
+		h.wipe()
+		if !expired {
+			continue
+		}
+		if err := s.cancelHandshake(id); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
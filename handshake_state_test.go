@@ -0,0 +1,162 @@
+package handshake
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/nomasters/handshake/lib/config"
+	"github.com/nomasters/handshake/lib/hashmapserver"
+	"github.com/nomasters/handshake/lib/ipfsserver"
+	"github.com/nomasters/handshake/lib/storage"
+)
+
+// listTestHandshakes returns the pending handshakes of the session
+func listTestHandshakes(t *testing.T, s *Session) []HandshakeSummary {
+	b, err := s.ListHandshakes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var summaries []HandshakeSummary
+	if err := json.Unmarshal(b, &summaries); err != nil {
+		t.Fatal(err)
+	}
+	return summaries
+}
+
+func TestPersistedHandshake(t *testing.T) {
+	rendezvous := httptest.NewServer(hashmapserver.New())
+	defer rendezvous.Close()
+	messages := httptest.NewServer(ipfsserver.New())
+	defer messages.Close()
+	initiatorPath := "persisted-initiator-handshake.boltdb"
+	peerPath := "persisted-peer-handshake.boltdb"
+	for _, path := range []string{initiatorPath, peerPath} {
+		os.Remove(path)
+		defer os.Remove(path)
+	}
+	opts := SessionOptions{StorageEngine: storage.BoltEngine, StorageFilePath: initiatorPath}
+
+	a := newGroupTestSession(t, initiatorPath, initiator, rendezvous.URL, messages.URL)
+	b := newGroupTestSession(t, peerPath, peer, rendezvous.URL, messages.URL)
+	defer b.Close()
+	if err := a.SetHandshakePadding(BlockPadding); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SetHandshakePadding(BlockPadding); err == nil {
+		t.Error("SetHandshakePadding returned no error for a peer")
+	}
+	share, err := b.ShareHandshakePosition()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.AddPeerToHandshake(share); err != nil {
+		t.Fatal(err)
+	}
+	summary, err := a.GetHandshakeSummary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Close()
+
+	// the initiator resumes the handshake in a new session
+	a, err = NewSession("group password", config.NewConfig(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	if list := listTestHandshakes(t, a); len(list) != 1 || list[0] != summary || list[0].Peers != 2 {
+		t.Fatalf("expected pending handshake %v, got %v", summary, list)
+	}
+	if err := a.ResumeHandshake(summary.ID); err != nil {
+		t.Fatal(err)
+	}
+	configs, err := a.GetAllHandshakePeerConfigs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.AddPeersToHandshake(configs); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.AddPeersToHandshake([]byte(`[{"entropy": "", "item": 3, "total_items": 3}]`)); err == nil {
+		t.Error("AddPeersToHandshake returned no error for a mismatched total")
+	}
+	entropy := a.activeHandshake.Position.Entropy
+	aChatID, err := a.NewChat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bChatID, err := b.NewChat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aChatID != bChatID {
+		t.Errorf("chat IDs differ: %v %v", aChatID, bChatID)
+	}
+	// both sides pad with the scheme of the initiator
+	for _, s := range []*Session{a, b} {
+		c, err := s.getChat(aChatID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Settings.Padding != BlockPadding {
+			t.Errorf("expected BlockPadding, got %v", c.Settings.Padding)
+		}
+	}
+	if !bytes.Equal(entropy, make([]byte, len(entropy))) {
+		t.Error("handshake entropy was not wiped")
+	}
+	for _, s := range []*Session{a, b} {
+		if list := listTestHandshakes(t, s); len(list) != 0 {
+			t.Errorf("expected no pending handshakes, got %v", list)
+		}
+	}
+
+	// cancelled and expired handshakes are wiped
+	if err := a.NewInitiatorWithDefaults(); err != nil {
+		t.Fatal(err)
+	}
+	cancelled, _ := a.GetHandshakeSummary()
+	if err := a.CancelHandshake(cancelled.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.ShareHandshakePosition(); err == nil {
+		t.Error("ShareHandshakePosition returned no error for a cancelled handshake")
+	}
+	for i := 0; i < 2; i++ {
+		if err := a.NewInitiatorWithDefaults(); err != nil {
+			t.Fatal(err)
+		}
+		a.activeHandshake.Created -= DefaultHandshakeTTL
+		if err := a.setHandshake(a.activeHandshake); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expired, _ := a.GetHandshakeSummary()
+	if err := a.ResumeHandshake(expired.ID); err != ErrHandshakeExpired {
+		t.Errorf("expected ErrHandshakeExpired, got %v", err)
+	}
+	if count, err := a.ExpireHandshakes(); err != nil || count != 1 {
+		t.Errorf("expected 1 expired handshake, got %v: %v", count, err)
+	}
+	if list := listTestHandshakes(t, a); len(list) != 0 {
+		t.Errorf("expected no pending handshakes, got %v", list)
+	}
+}
+
+func TestHandshakeIDsFromPaths(t *testing.T) {
+	profileID := "a1b2"
+	list := []string{
+		"handshakes/c3d4/a1b2",
+		"handshakes/e5f6/a1b2/extra",
+		"handshakes/a1b2/e5f6",
+		"handshakes/a1b2x/e5f6",
+		"handshakes//a1b2",
+		"chats/c3d4/a1b2",
+	}
+	if ids := handshakeIDsFromPaths(list, profileID); len(ids) != 1 || ids[0] != "c3d4" {
+		t.Errorf("expected [c3d4], got %v", ids)
+	}
+}
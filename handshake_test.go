@@ -1,8 +1,18 @@
 package handshake
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"reflect"
 	"testing"
+
+	"github.com/nomasters/handshake/lib/config"
+	"github.com/nomasters/handshake/lib/hashmapserver"
+	"github.com/nomasters/handshake/lib/ipfsserver"
+	"github.com/nomasters/handshake/lib/storage"
 )
 
 func TestNewHandshake(t *testing.T) {
@@ -25,3 +35,130 @@ func TestNewHandshake(t *testing.T) {
 	}
 	t.Log(h2)
 }
+
+// newGroupTestSession returns a Session for a new profile stored at path with a handshake for the role that
+// uses the local rendezvous and message servers
+func newGroupTestSession(t *testing.T, path string, r role, rendezvousURL, storageURL string) *Session {
+	password := "group password"
+	cfg := config.NewConfig()
+	store, err := storage.NewStorage(cfg, storage.Options{Engine: storage.BoltEngine, FilePath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := initProfile(generateRandomProfile(), password, DefaultKDFParams, newTimeSeriesSBCipher(), store); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+	s, err := NewSession(password, cfg, SessionOptions{StorageEngine: storage.BoltEngine, StorageFilePath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	strategy := strategy{
+		Rendezvous: storage.NewHashmapRendezvous(rendezvousURL),
+		Storage:    storage.NewIPFSMessageStorage(storage.Node{URL: storageURL}),
+		Cipher:     newDefaultCipher(),
+		LookupKDF:  KDFParams{Version: Argon2idKDF, Time: 1, Memory: 8 * 1024, Threads: 1},
+		Padding:    DefaultPaddingScheme,
+	}
+	if err := s.startHandshake(newHandshake(strategy, handshakeOptions{Role: r})); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestGroupHandshake(t *testing.T) {
+	rendezvous := httptest.NewServer(hashmapserver.New())
+	defer rendezvous.Close()
+	messages := httptest.NewServer(ipfsserver.New())
+	defer messages.Close()
+
+	for total := 3; total <= 5; total++ {
+		t.Run(fmt.Sprintf("%v participants", total), func(t *testing.T) {
+			sessions := make([]*Session, total)
+			for i := range sessions {
+				path := fmt.Sprintf("group-%v-%v-handshake.boltdb", total, i)
+				os.Remove(path)
+				defer os.Remove(path)
+				r := peer
+				if i == 0 {
+					r = initiator
+				}
+				sessions[i] = newGroupTestSession(t, path, r, rendezvous.URL, messages.URL)
+				defer sessions[i].Close()
+			}
+
+			// the initiator collects a share from every joiner and hands each joiner every config
+			for _, joiner := range sessions[1:] {
+				share, err := joiner.ShareHandshakePosition()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := sessions[0].AddPeerToHandshake(share); err != nil {
+					t.Fatal(err)
+				}
+			}
+			configs, err := sessions[0].GetAllHandshakePeerConfigs()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, joiner := range sessions[1:] {
+				received, err := joiner.AddPeersToHandshake(configs)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !received {
+					t.Fatal("joiner did not receive all peers")
+				}
+			}
+
+			var chatID string
+			for i, s := range sessions {
+				id, err := s.NewChat()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if i > 0 && id != chatID {
+					t.Fatalf("chat IDs differ: %v %v", chatID, id)
+				}
+				chatID = id
+			}
+			first, err := sessions[0].getChat(chatID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(first.Peers) != total {
+				t.Fatalf("expected %v peers, got %v", total, len(first.Peers))
+			}
+			for peerID := range first.Peers {
+				expected, err := sessions[0].getLookup(chatID, peerID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, s := range sessions[1:] {
+					if l, err := s.getLookup(chatID, peerID); err != nil || !reflect.DeepEqual(l, expected) {
+						t.Fatalf("lookups for peer %v differ: %v", peerID, err)
+					}
+				}
+			}
+
+			for i, sender := range sessions {
+				m := []byte(fmt.Sprintf(`{ "message": "hello from %v" }`, i))
+				if _, err := sender.SendMessage(chatID, m); err != nil {
+					t.Fatal(err)
+				}
+				for j, s := range sessions {
+					if j == i {
+						continue
+					}
+					log, err := s.RetrieveMessages(chatID)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Contains(log, []byte(fmt.Sprintf("hello from %v", i))) {
+						t.Errorf("participant %v did not receive the message from %v", j, i)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package handshake
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// KDFVersion is used for type enumeration of the key derivation functions and parameter sets used by handshake
+type KDFVersion uint8
+
+const (
+	// LegacyKDF is the KDFVersion for the fixed argon2id parameters used before KDF parameters were versioned.
+	// Profiles and lookups that carry no KDFParams are derived with this version.
+	LegacyKDF KDFVersion = iota
+	// Argon2idKDF is the KDFVersion for argon2id with configurable parameters
+	Argon2idKDF
+)
+
+const (
+	// currentKDFVersion is the KDFVersion that profiles are upgraded to on login
+	currentKDFVersion = Argon2idKDF
+	// kdfMaxTime is the maximum number of argon2 passes accepted in KDFParams
+	kdfMaxTime = 64
+	// kdfMaxMemory is the maximum argon2 memory, in KiB, accepted in KDFParams. This bounds the
+	// memory a peer can make us spend when deriving lookups.
+	kdfMaxMemory = 1024 * 1024
+	// profileHeaderLength is the length in bytes of the KDF header stored in front of an encrypted profile
+	profileHeaderLength = 14
+)
+
+// profileHeaderMagic marks a profile that was stored with a KDF header. Profiles stored without one start
+// with a TimeSeriesNonce, whose most significant time byte won't reach 0xff until the year 2105.
+var profileHeaderMagic = []byte{'h', 's', 'k', 0xff}
+
+// KDFParams holds the parameters used to derive keys from passwords and handshake entropy
+type KDFParams struct {
+	Version KDFVersion `json:"version"`
+	Time    uint32     `json:"time"`
+	Memory  uint32     `json:"memory"`
+	Threads uint8      `json:"threads"`
+}
+
+var (
+	// DefaultKDFParams are the KDFParams used for new profiles and chats. Memory is in KiB.
+	DefaultKDFParams = KDFParams{Version: Argon2idKDF, Time: 1, Memory: 64 * 1024, Threads: 4}
+	// legacyKDFParams are the fixed parameters used by LegacyKDF
+	legacyKDFParams = KDFParams{Version: LegacyKDF, Time: 1, Memory: 64 * 1024, Threads: 4}
+)
+
+// Validate returns an error if the KDFParams are unknown or outside the accepted bounds
+func (p KDFParams) Validate() error {
+	switch p.Version {
+	case LegacyKDF:
+		return nil
+	case Argon2idKDF:
+	default:
+		return fmt.Errorf("unknown kdf version: %v", p.Version)
+	}
+	if p.Time < 1 || p.Time > kdfMaxTime {
+		return fmt.Errorf("kdf time must be between 1 and %v", kdfMaxTime)
+	}
+	if p.Threads < 1 {
+		return errors.New("kdf threads must be greater than or equal to 1")
+	}
+	if p.Memory < 8*uint32(p.Threads) || p.Memory > kdfMaxMemory {
+		return fmt.Errorf("kdf memory must be between %v and %v KiB", 8*uint32(p.Threads), kdfMaxMemory)
+	}
+	return nil
+}
+
+// key takes a password and salt and returns a key of length l derived with the KDFParams and an error
+func (p KDFParams) key(pw, salt []byte, l uint32) ([]byte, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	if p.Version == LegacyKDF {
+		p = legacyKDFParams
+	}
+	return argon2.IDKey(pw, salt, p.Time, p.Memory, p.Threads, l), nil
+}
+
+// encodeProfileBlob takes KDFParams and an encrypted profile and returns the profile prefixed with a KDF
+// header. LegacyKDF profiles are returned without a header.
+func encodeProfileBlob(p KDFParams, encrypted []byte) []byte {
+	if p.Version == LegacyKDF {
+		return encrypted
+	}
+	b := make([]byte, profileHeaderLength, profileHeaderLength+len(encrypted))
+	copy(b, profileHeaderMagic)
+	b[4] = byte(p.Version)
+	binary.BigEndian.PutUint32(b[5:9], p.Time)
+	binary.BigEndian.PutUint32(b[9:13], p.Memory)
+	b[13] = p.Threads
+	return append(b, encrypted...)
+}
+
+// decodeProfileBlob takes a stored profile and returns the KDFParams used to derive its key, the encrypted
+// profile, and an error. Profiles without a KDF header return legacy parameters.
+func decodeProfileBlob(b []byte) (KDFParams, []byte, error) {
+	if len(b) < profileHeaderLength || !bytes.Equal(b[:len(profileHeaderMagic)], profileHeaderMagic) {
+		return legacyKDFParams, b, nil
+	}
+	p := KDFParams{
+		Version: KDFVersion(b[4]),
+		Time:    binary.BigEndian.Uint32(b[5:9]),
+		Memory:  binary.BigEndian.Uint32(b[9:13]),
+		Threads: b[13],
+	}
+	if p.Version == LegacyKDF {
+		return p, nil, errors.New("invalid profile header: legacy kdf version")
+	}
+	if err := p.Validate(); err != nil {
+		return p, nil, err
+	}
+	return p, b[profileHeaderLength:], nil
+}
@@ -0,0 +1,88 @@
+package handshake
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/nomasters/handshake/lib/config"
+	"github.com/nomasters/handshake/lib/storage"
+)
+
+func TestProfileBlob(t *testing.T) {
+	t.Parallel()
+
+	encrypted := []byte("encrypted profile")
+	params := KDFParams{Version: Argon2idKDF, Time: 3, Memory: 32 * 1024, Threads: 2}
+	p, b, err := decodeProfileBlob(encodeProfileBlob(params, encrypted))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p != params || !bytes.Equal(b, encrypted) {
+		t.Errorf("profile blob round trip failed: %v %v", p, string(b))
+	}
+
+	legacy := append(genTimeStampNonce(secretBoxNonceLength), encrypted...)
+	p, b, err = decodeProfileBlob(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p != legacyKDFParams || !bytes.Equal(b, legacy) {
+		t.Error("profile without header was not decoded as legacy")
+	}
+
+	invalid := encodeProfileBlob(KDFParams{Version: Argon2idKDF, Time: 1, Memory: 1, Threads: 4}, encrypted)
+	if _, _, err := decodeProfileBlob(invalid); err == nil {
+		t.Error("decodeProfileBlob returned no error for invalid params")
+	}
+	if err := (KDFParams{Version: KDFVersion(99)}).Validate(); err == nil {
+		t.Error("Validate returned no error for unknown version")
+	}
+}
+
+func TestLegacyProfileUpgrade(t *testing.T) {
+	path := "kdf-handshake.boltdb"
+	os.Remove(path)
+	defer os.Remove(path)
+	password := "legacy password"
+
+	cfg := config.NewConfig()
+	store, err := storage.NewStorage(cfg, storage.Options{Engine: storage.BoltEngine, FilePath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := generateRandomProfile()
+	if err := initProfile(p, password, legacyKDFParams, newTimeSeriesSBCipher(), store); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	opts := SessionOptions{StorageEngine: storage.BoltEngine, StorageFilePath: path}
+	s, err := NewSession(password, cfg, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.GetProfile().ID != p.ID || !bytes.Equal(s.GetProfile().Key, p.Key) {
+		t.Error("legacy profile did not match")
+	}
+	if s.locker.KDF != DefaultKDFParams {
+		t.Errorf("expected session kdf to be upgraded, got %v", s.locker.KDF)
+	}
+	data, err := s.storage.Get(profileKeyPrefix + p.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params, _, _ := decodeProfileBlob(data); params != DefaultKDFParams {
+		t.Errorf("expected stored profile to be upgraded, got %v", params)
+	}
+	s.Close()
+
+	s, err = NewSession(password, cfg, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	if _, err := NewSession("junk password", cfg, opts); err == nil {
+		t.Error("NewSession returned no error with bad password")
+	}
+}
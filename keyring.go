@@ -0,0 +1,34 @@
+package handshake
+
+import (
+	"sync"
+)
+
+// keyring tracks key material held by a Session so that it can be wiped when the Session is closed or
+// locked. Where the platform allows, tracked keys are locked in memory so they are not swapped to disk.
+type keyring struct {
+	mu   sync.Mutex
+	keys [][]byte
+}
+
+// add takes a key, locks its memory, and tracks it until the keyring is wiped
+func (k *keyring) add(key []byte) {
+	if len(key) == 0 {
+		return
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	lockMemory(key)
+	k.keys = append(k.keys, key)
+}
+
+// wipe zeroes and unlocks all tracked keys
+func (k *keyring) wipe() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for _, key := range k.keys {
+		zeroBytes(key)
+		unlockMemory(key)
+	}
+	k.keys = nil
+}
@@ -0,0 +1,65 @@
+package handshake
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestKeyring(t *testing.T) {
+	t.Parallel()
+
+	var k keyring
+	key1 := genRandBytes(32)
+	key2 := genRandBytes(32)
+	k.add(key1)
+	k.add(key2)
+	k.add(nil)
+	k.wipe()
+	zero := make([]byte, 32)
+	if !bytes.Equal(key1, zero) || !bytes.Equal(key2, zero) {
+		t.Error("keyring wipe did not zero keys")
+	}
+	if len(k.keys) != 0 {
+		t.Error("keyring wipe did not release keys")
+	}
+}
+
+func TestSessionCloseWipesKeys(t *testing.T) {
+	path := "wipe-keys-handshake.boltdb"
+	os.Remove(path)
+	defer os.Remove(path)
+
+	s := newRotationTestSession(t, path, "wipe password", "a1b2c3")
+	profileKey := s.GetProfile().Key
+	lockerKey := s.locker.Key
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zero := make([]byte, profileKeyLength)
+	if !bytes.Equal(profileKey, zero) {
+		t.Error("profile key was not zeroed on Close")
+	}
+	if !bytes.Equal(lockerKey, zero) {
+		t.Error("profile locker key was not zeroed on Close")
+	}
+}
+
+func TestLookupWipe(t *testing.T) {
+	t.Parallel()
+
+	l := lookup{"a": genRandBytes(32), "b": genRandBytes(32)}
+	k, v := l.popRandom()
+	if _, ok := l[k]; ok {
+		t.Error("popRandom did not remove the key")
+	}
+	zeroBytes(v)
+	remaining := l.get("a")
+	if k == "a" {
+		remaining = l.get("b")
+	}
+	l.wipe()
+	if !bytes.Equal(remaining, make([]byte, 32)) || !bytes.Equal(v, make([]byte, 32)) {
+		t.Error("lookup keys were not zeroed")
+	}
+}
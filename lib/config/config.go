@@ -1,7 +1,11 @@
 package config
 
 import (
+	"crypto/hmac"
 	"encoding/json"
+	"errors"
+
+	"golang.org/x/crypto/blake2b"
 )
 
 const (
@@ -9,6 +13,26 @@ const (
 	DefaultSessionTTL = 15 * 60 // 15 minutes in seconds
 	// DefaultMaxLoginAttempts is the number of times failed login attempts are allowed
 	DefaultMaxLoginAttempts = 10
+	// DefaultLockoutDelay is the delay in seconds after the first failed login attempt beyond MaxLoginAttempts.
+	// The delay doubles with each additional failed attempt.
+	DefaultLockoutDelay = 60
+	// MaxLockoutDelay is the maximum delay in seconds between login attempts with the LockoutPolicy
+	MaxLockoutDelay = 24 * 60 * 60
+)
+
+// LoginPolicy is the policy applied once FailedLoginAttempts reaches MaxLoginAttempts. The global config that
+// holds the policy and the failed login counter is signed with a MAC key stored in the same data store, so it
+// protects against editing the config on its own, but not against anyone able to write the data store, who can
+// re-sign a config with that key. Full disk encryption is needed to protect the counter from them.
+type LoginPolicy string
+
+const (
+	// LockoutPolicy delays login attempts exponentially once MaxLoginAttempts is reached
+	LockoutPolicy LoginPolicy = "lockout"
+	// WipePolicy deletes all profiles and chats once MaxLoginAttempts is reached
+	WipePolicy LoginPolicy = "wipe"
+	// DefaultLoginPolicy is the LoginPolicy used for new data stores
+	DefaultLoginPolicy = LockoutPolicy
 )
 
 // Config holds global settings used by the app
@@ -17,6 +41,9 @@ type Config struct {
 	TTL                 int
 	FailedLoginAttempts int
 	MaxLoginAttempts    int
+	LoginPolicy         LoginPolicy `json:",omitempty"`
+	LastFailedLogin     int64       `json:",omitempty"`
+	MAC                 []byte      `json:",omitempty"`
 }
 
 // NewConfig creates a new global config struct with default settings.
@@ -26,11 +53,77 @@ func NewConfig() Config {
 		TTL:                 DefaultSessionTTL,
 		FailedLoginAttempts: 0,
 		MaxLoginAttempts:    DefaultMaxLoginAttempts,
+		LoginPolicy:         DefaultLoginPolicy,
 	}
 }
 
+// FromJSON takes json encoded bytes and returns a Config and error
+func FromJSON(b []byte) (Config, error) {
+	var g Config
+	err := json.Unmarshal(b, &g)
+	return g, err
+}
+
 // ToJSON is a helper method for GlobalConfig
 func (g Config) ToJSON() []byte {
 	b, _ := json.Marshal(g)
 	return b
 }
+
+// Policy returns the LoginPolicy for the Config, defaulting to DefaultLoginPolicy for configs that
+// predate login policies
+func (g Config) Policy() LoginPolicy {
+	if g.LoginPolicy == "" {
+		return DefaultLoginPolicy
+	}
+	return g.LoginPolicy
+}
+
+// LockoutDelay returns the delay in seconds required after LastFailedLogin before another login attempt
+// is allowed with the LockoutPolicy
+func (g Config) LockoutDelay() int64 {
+	if g.MaxLoginAttempts <= 0 || g.FailedLoginAttempts < g.MaxLoginAttempts {
+		return 0
+	}
+	delay := int64(DefaultLockoutDelay)
+	for i := g.MaxLoginAttempts; i < g.FailedLoginAttempts && delay < MaxLockoutDelay; i++ {
+		delay *= 2
+	}
+	if delay > MaxLockoutDelay {
+		delay = MaxLockoutDelay
+	}
+	return delay
+}
+
+// Sign takes a key and returns the Config with a MAC of its settings and an error
+func (g Config) Sign(key []byte) (Config, error) {
+	mac, err := g.sum(key)
+	if err != nil {
+		return g, err
+	}
+	g.MAC = mac
+	return g, nil
+}
+
+// Verify takes a key and returns true if the MAC of the Config is valid
+func (g Config) Verify(key []byte) bool {
+	mac, err := g.sum(key)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(mac, g.MAC)
+}
+
+// sum takes a key and returns a keyed blake2b-256 hash of the Config without its MAC and an error
+func (g Config) sum(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, errors.New("config mac key must not be empty")
+	}
+	g.MAC = nil
+	h, err := blake2b.New256(key)
+	if err != nil {
+		return nil, err
+	}
+	h.Write(g.ToJSON())
+	return h.Sum(nil), nil
+}
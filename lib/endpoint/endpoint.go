@@ -0,0 +1,32 @@
+package endpoint
+
+import (
+	multihash "github.com/multiformats/go-multihash"
+)
+
+const (
+	blake2b256code   = uint64(45600)
+	blake2b256length = 32
+	blake2b256name   = "blake2b-256"
+)
+
+// Base58Multihash takes a set of bytes and returns the IPFS style blake2b-256 multihash in base58 encoding
+// that hashmap uses to name the endpoint of a public key
+func Base58Multihash(b []byte) string {
+	mh, _ := multihash.Sum(b, blake2b256code, blake2b256length)
+	return mh.B58String()
+}
+
+// IsHashmapMultihash takes a string encoded base58 multihash and checks to see if it is a
+// blake2b-256 multihash, which is the only type supported by hashmap endpoints
+func IsHashmapMultihash(hash string) bool {
+	mh, err := multihash.FromB58String(hash)
+	if err != nil {
+		return false
+	}
+	decoded, err := multihash.Decode(mh)
+	if err != nil {
+		return false
+	}
+	return decoded.Name == blake2b256name
+}
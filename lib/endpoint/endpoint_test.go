@@ -0,0 +1,17 @@
+package endpoint
+
+import "testing"
+
+func TestBase58Multihash(t *testing.T) {
+	hash := Base58Multihash([]byte("public key"))
+	if !IsHashmapMultihash(hash) {
+		t.Errorf("%v is not a hashmap multihash", hash)
+	}
+	// the well known hash of `echo "hello world" | ipfs add` is sha2-256
+	if IsHashmapMultihash("QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o") {
+		t.Error("sha2-256 multihash accepted as a hashmap multihash")
+	}
+	if IsHashmapMultihash("not a multihash") {
+		t.Error("invalid multihash accepted as a hashmap multihash")
+	}
+}
@@ -0,0 +1,128 @@
+package hashmapserver
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nomasters/hashmap"
+
+	"github.com/nomasters/handshake/lib/endpoint"
+)
+
+const (
+	// maxFutureDrift is the furthest into the future, in nanoseconds, a payload timestamp may be set
+	maxFutureDrift = 5 * int64(time.Second)
+)
+
+var (
+	// ErrStaleTimestamp is returned when a payload timestamp is not newer than the stored payload
+	ErrStaleTimestamp = errors.New("stale timestamp")
+	// ErrFutureTimestamp is returned when a payload timestamp is set too far in the future
+	ErrFutureTimestamp = errors.New("invalid future timestamp")
+)
+
+// Server is an in-memory hashmap compatible server. It conforms to the http.Handler interface so that
+// it can be used with an httptest.Server in tests or with http.ListenAndServe for self-hosting.
+// Payloads are submitted with a POST to `/` and retrieved with a GET to `/<multihash>`, where the
+// multihash is the base58 encoded blake2b-256 multihash of the payload public key.
+type Server struct {
+	mu       sync.RWMutex
+	payloads map[string]entry
+}
+
+// entry is a stored payload and its timestamp
+type entry struct {
+	Timestamp int64
+	Payload   []byte
+}
+
+// New returns a pointer to an empty Server
+func New() *Server {
+	return &Server{payloads: make(map[string]entry)}
+}
+
+// ServeHTTP routes GET and POST requests to the appropriate handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.handleGet(w, r)
+	case http.MethodPost:
+		s.handlePost(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleGet(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Path, "/")
+	if !endpoint.IsHashmapMultihash(path) {
+		http.Error(w, "invalid multihash", http.StatusBadRequest)
+		return
+	}
+	s.mu.RLock()
+	e, ok := s.payloads[path]
+	s.mu.RUnlock()
+	if !ok {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(e.Payload)
+}
+
+func (s *Server) handlePost(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, hashmap.MaxPostBodySize))
+	if err != nil {
+		http.Error(w, "payload too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	path, timestamp, err := verifyPayload(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.set(path, timestamp, body); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(path))
+}
+
+// set stores a payload for an endpoint, only if the timestamp is newer than the currently stored payload
+func (s *Server) set(endpoint string, timestamp int64, payload []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.payloads[endpoint]; ok && timestamp <= e.Timestamp {
+		return ErrStaleTimestamp
+	}
+	s.payloads[endpoint] = entry{Timestamp: timestamp, Payload: payload}
+	return nil
+}
+
+// verifyPayload takes the bytes of a submitted payload, verifies the ed25519 signature and timestamp
+// and returns the multihash endpoint derived from the public key, the timestamp, and an error.
+// NewPayloadFromReader verifies the signature and the hashmap message requirements.
+func verifyPayload(body []byte) (string, int64, error) {
+	payload, err := hashmap.NewPayloadFromReader(bytes.NewReader(body))
+	if err != nil {
+		return "", 0, errors.New("invalid payload")
+	}
+	pubkey, err := payload.PubKeyBytes()
+	if err != nil {
+		return "", 0, errors.New("invalid pubkey")
+	}
+	data, err := payload.GetData()
+	if err != nil {
+		return "", 0, errors.New("invalid payload data")
+	}
+	if data.Timestamp > time.Now().UnixNano()+maxFutureDrift {
+		return "", 0, ErrFutureTimestamp
+	}
+	return endpoint.Base58Multihash(pubkey), data.Timestamp, nil
+}
@@ -0,0 +1,123 @@
+package hashmapserver
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nomasters/hashmap"
+
+	"github.com/nomasters/handshake/lib/endpoint"
+)
+
+func TestServer(t *testing.T) {
+	server := httptest.NewServer(New())
+	defer server.Close()
+
+	privateKey := hashmap.GenerateKey()
+	path := endpoint.Base58Multihash(privateKey[32:])
+	payload, err := hashmap.GeneratePayload(hashmap.GeneratePayloadOptions{Message: "hello, world"}, privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Get(server.URL + "/" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected %v before submission, got %v", http.StatusNotFound, resp.StatusCode)
+	}
+
+	resp, err = http.Post(server.URL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected %v on submission, got %v", http.StatusOK, resp.StatusCode)
+	}
+
+	resp, err = http.Get(server.URL + "/" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	p, err := hashmap.NewPayloadFromReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := p.GetData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	message, err := data.MessageBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(message) != "hello, world" {
+		t.Errorf("expected hello, world, got %v", string(message))
+	}
+
+	// replaying the same payload must be rejected since the timestamp is not newer
+	resp, err = http.Post(server.URL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("expected %v on replay, got %v", http.StatusConflict, resp.StatusCode)
+	}
+
+	resp, err = http.Get(server.URL + "/not-a-multihash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected %v for invalid endpoint, got %v", http.StatusBadRequest, resp.StatusCode)
+	}
+}
+
+func TestServerRejectsInvalidSignature(t *testing.T) {
+	server := httptest.NewServer(New())
+	defer server.Close()
+
+	keyA := hashmap.GenerateKey()
+	keyB := hashmap.GenerateKey()
+	payloadA, err := hashmap.GeneratePayload(hashmap.GeneratePayloadOptions{Message: "a"}, keyA)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// swap the public key in payload A for the public key of B, which invalidates the signature
+	var fields map[string]interface{}
+	if err := json.Unmarshal(payloadA, &fields); err != nil {
+		t.Fatal(err)
+	}
+	pubkeyA := base64.StdEncoding.EncodeToString(keyA[32:])
+	for k, v := range fields {
+		if v == pubkeyA {
+			fields[k] = base64.StdEncoding.EncodeToString(keyB[32:])
+		}
+	}
+	forged, err := json.Marshal(fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Post(server.URL, "application/json", bytes.NewReader(forged))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected %v for forged payload, got %v: %v", http.StatusBadRequest, resp.StatusCode, string(body))
+	}
+}
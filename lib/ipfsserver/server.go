@@ -0,0 +1,130 @@
+package ipfsserver
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/nomasters/handshake/lib/unixfs"
+)
+
+const (
+	addEndpoint     = "/api/v0/add"
+	catEndpoint     = "/api/v0/cat"
+	gatewayEndpoint = "/ipfs/"
+	// maxMultipartMemory is the amount of a multipart form body held in memory while parsing
+	maxMultipartMemory = 1 << 20 // 1MB
+)
+
+// Server is an in-memory, content addressed stand-in for an IPFS node. It conforms to the http.Handler
+// interface so that it can be used with an httptest.Server in tests or with http.ListenAndServe for
+// self-hosting. It supports both query types used by storage.IPFSStorage:
+// - the `api/v0/add` and `api/v0/cat` RPC endpoints
+// - a writable `/ipfs/` gateway that returns the hash in the `Ipfs-Hash` header
+// Objects are addressed by the same CIDv0 a real IPFS node would produce, so objects are limited
+// to a single IPFS chunk.
+type Server struct {
+	mu      sync.RWMutex
+	objects map[string][]byte
+}
+
+// addResponse is the JSON body returned from the api/v0/add endpoint
+type addResponse struct {
+	Name string
+	Hash string
+	Size string
+}
+
+// New returns a pointer to an empty Server
+func New() *Server {
+	return &Server{objects: make(map[string][]byte)}
+}
+
+// ServeHTTP routes requests to the handler for the api or gateway query type
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == addEndpoint && r.Method == http.MethodPost:
+		s.handleAPIAdd(w, r)
+	case r.URL.Path == catEndpoint && (r.Method == http.MethodGet || r.Method == http.MethodPost):
+		s.handleCat(w, r.URL.Query().Get("arg"))
+	case strings.TrimSuffix(r.URL.Path, "/")+"/" == gatewayEndpoint && r.Method == http.MethodPost:
+		s.handleGatewayAdd(w, r)
+	case strings.HasPrefix(r.URL.Path, gatewayEndpoint) && r.Method == http.MethodGet:
+		s.handleCat(w, strings.TrimPrefix(r.URL.Path, gatewayEndpoint))
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+func (s *Server) handleAPIAdd(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 2*unixfs.ChunkSize)
+	if err := r.ParseMultipartForm(maxMultipartMemory); err != nil {
+		http.Error(w, "invalid multipart form", http.StatusBadRequest)
+		return
+	}
+	f, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "missing file", http.StatusBadRequest)
+		return
+	}
+	defer f.Close()
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		http.Error(w, "invalid file", http.StatusBadRequest)
+		return
+	}
+	hash, code := s.add(b)
+	if code != http.StatusOK {
+		http.Error(w, http.StatusText(code), code)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(addResponse{
+		Name: header.Filename,
+		Hash: hash,
+		Size: strconv.Itoa(len(b)),
+	})
+}
+
+func (s *Server) handleGatewayAdd(w http.ResponseWriter, r *http.Request) {
+	b, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, unixfs.ChunkSize+1))
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		return
+	}
+	hash, code := s.add(b)
+	if code != http.StatusOK {
+		http.Error(w, http.StatusText(code), code)
+		return
+	}
+	w.Header().Set("Ipfs-Hash", hash)
+	w.Header().Set("Location", gatewayEndpoint+hash)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) handleCat(w http.ResponseWriter, hash string) {
+	s.mu.RLock()
+	b, ok := s.objects[hash]
+	s.mu.RUnlock()
+	if !ok {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(b)
+}
+
+// add stores a set of bytes by its CIDv0 and returns the hash and an http status code
+func (s *Server) add(b []byte) (string, int) {
+	if len(b) > unixfs.ChunkSize {
+		return "", http.StatusRequestEntityTooLarge
+	}
+	hash := unixfs.FileMultihash(b)
+	s.mu.Lock()
+	s.objects[hash] = b
+	s.mu.Unlock()
+	return hash, http.StatusOK
+}
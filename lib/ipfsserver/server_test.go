@@ -0,0 +1,87 @@
+package ipfsserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const helloWorldHash = "QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o"
+
+func TestServerAPI(t *testing.T) {
+	server := httptest.NewServer(New())
+	defer server.Close()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	fileWriter, err := writer.CreateFormFile("file", "file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileWriter.Write([]byte("hello world\n"))
+	writer.Close()
+
+	resp, err := http.Post(server.URL+addEndpoint, writer.FormDataContentType(), body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var output addResponse
+	if err := json.NewDecoder(resp.Body).Decode(&output); err != nil {
+		t.Fatal(err)
+	}
+	if output.Hash != helloWorldHash {
+		t.Errorf("expected %v, got %v", helloWorldHash, output.Hash)
+	}
+
+	resp, err = http.Get(server.URL + catEndpoint + "?arg=" + helloWorldHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(resp.Body)
+	if string(b) != "hello world\n" {
+		t.Errorf("expected hello world, got %v", string(b))
+	}
+}
+
+func TestServerGateway(t *testing.T) {
+	server := httptest.NewServer(New())
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+gatewayEndpoint, "application/octet-stream", bytes.NewReader([]byte("hello world\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("expected %v, got %v", http.StatusCreated, resp.StatusCode)
+	}
+	hash := resp.Header.Get("Ipfs-Hash")
+	if hash != helloWorldHash {
+		t.Errorf("expected %v, got %v", helloWorldHash, hash)
+	}
+
+	resp, err = http.Get(server.URL + gatewayEndpoint + hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(resp.Body)
+	if string(b) != "hello world\n" {
+		t.Errorf("expected hello world, got %v", string(b))
+	}
+
+	resp, err = http.Get(server.URL + gatewayEndpoint + "QmNotStored")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected %v, got %v", http.StatusNotFound, resp.StatusCode)
+	}
+}
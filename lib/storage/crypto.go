@@ -1,35 +1,35 @@
 package storage
 
 import (
+	"fmt"
+
 	multihash "github.com/multiformats/go-multihash"
-)
 
-const (
-	blake2b256code   = uint64(45600)
-	blake2b256length = 32
-	blake2b256name   = "blake2b-256"
+	"github.com/nomasters/handshake/lib/unixfs"
 )
 
-// base58Multihash a set of bytes to an IPFS style blake2b-256 multihash in base58 encoding
-func base58Multihash(b []byte) string {
-	mh, _ := multihash.Sum(b, blake2b256code, blake2b256length)
-	return mh.B58String()
+// ipfsMultihash takes a set of bytes and returns the base58 encoded CIDv0 that an IPFS node would
+// generate when adding the bytes as a single block file
+func ipfsMultihash(b []byte) string {
+	return unixfs.FileMultihash(b)
 }
 
-// isHashmapMultihash takes a string encoded base58 multihash and checks to see if it is supported
-// by handshake. Currently, handshake only supports
-func isHashmapMultihash(hash string) bool {
+// verifyIPFSMultihash takes a base58 encoded CIDv0 and a set of bytes and returns an error if the bytes
+// do not hash to the CIDv0
+func verifyIPFSMultihash(hash string, b []byte) error {
 	mh, err := multihash.FromB58String(hash)
 	if err != nil {
-		return false // return false if error decoding
+		return fmt.Errorf("unsupported ipfs hash: %v", hash)
 	}
 	decoded, err := multihash.Decode(mh)
 	if err != nil {
-		return false
+		return fmt.Errorf("unsupported ipfs hash: %v", hash)
+	}
+	if decoded.Code != multihash.SHA2_256 {
+		return fmt.Errorf("unsupported ipfs hash type: %v", decoded.Name)
 	}
-	switch decoded.Name {
-	case blake2b256name:
-		return true
+	if ipfsMultihash(b) != hash {
+		return ErrHashMismatch
 	}
-	return false
+	return nil
 }
@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"crypto/rand"
+	"errors"
+
+	"github.com/nomasters/handshake/lib/config"
+)
+
+// ErrGlobalConfigTampered is returned when the global-config fails its integrity check
+var ErrGlobalConfigTampered = errors.New("global config failed integrity check")
+
+// GetGlobalConfig takes a Storage interface and returns the global-config and an error. The global-config is
+// verified with a MAC keyed by a random key stored alongside it, so that the config can't be changed, such as
+// resetting FailedLoginAttempts, by editing its json. Device storage is created with both the global-config and
+// its MAC key, so if either is missing, or verification fails, ErrGlobalConfigTampered is returned. A config
+// without a MAC in a data store without a MAC key predates the MAC, and is signed on first read.
+func GetGlobalConfig(s Storage) (config.Config, error) {
+	b, err := s.Get(globalConfigKey)
+	if err != nil {
+		return config.Config{}, err
+	}
+	if len(b) == 0 {
+		return config.Config{}, ErrGlobalConfigTampered
+	}
+	cfg, err := config.FromJSON(b)
+	if err != nil {
+		return cfg, ErrGlobalConfigTampered
+	}
+	key, err := s.Get(globalConfigMACKey)
+	if err != nil {
+		return cfg, err
+	}
+	if len(key) == 0 && len(cfg.MAC) == 0 {
+		return cfg, ResetGlobalConfig(s, cfg)
+	}
+	if len(key) == 0 || !cfg.Verify(key) {
+		return cfg, ErrGlobalConfigTampered
+	}
+	return cfg, nil
+}
+
+// SetGlobalConfig takes a Storage interface and a config and stores the config signed with the global-config
+// MAC key. ErrGlobalConfigTampered is returned if the MAC key is missing.
+func SetGlobalConfig(s Storage, cfg config.Config) error {
+	key, err := s.Get(globalConfigMACKey)
+	if err != nil {
+		return err
+	}
+	if len(key) == 0 {
+		return ErrGlobalConfigTampered
+	}
+	return setSignedGlobalConfig(s, cfg, key)
+}
+
+// ResetGlobalConfig takes a Storage interface and a config and stores the config signed with a newly generated
+// MAC key. It is only meant for signing a config that predates the MAC, or storing a locked out config after
+// the global-config failed its integrity check, since the new key makes any config it signs verify.
+func ResetGlobalConfig(s Storage, cfg config.Config) error {
+	key := make([]byte, globalConfigMACLength)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	if _, err := s.Set(globalConfigMACKey, key); err != nil {
+		return err
+	}
+	return setSignedGlobalConfig(s, cfg, key)
+}
+
+func setSignedGlobalConfig(s Storage, cfg config.Config, key []byte) error {
+	signed, err := cfg.Sign(key)
+	if err != nil {
+		return err
+	}
+	_, err = s.Set(globalConfigKey, signed.ToJSON())
+	return err
+}
@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"os"
+	"testing"
+
+	"github.com/nomasters/handshake/lib/config"
+)
+
+func TestGlobalConfig(t *testing.T) {
+	path := "global-config.boltdb"
+	os.Remove(path)
+	defer os.Remove(path)
+
+	s, err := NewStorage(config.NewConfig(), Options{Engine: BoltEngine, FilePath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	cfg, err := GetGlobalConfig(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.FailedLoginAttempts = 3
+	if err := SetGlobalConfig(s, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg, err = GetGlobalConfig(s); err != nil || cfg.FailedLoginAttempts != 3 {
+		t.Fatalf("expected 3 failed login attempts, got %v: %v", cfg.FailedLoginAttempts, err)
+	}
+
+	cfg.FailedLoginAttempts = 0
+	if _, err := s.Set(globalConfigKey, cfg.ToJSON()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetGlobalConfig(s); err != ErrGlobalConfigTampered {
+		t.Errorf("expected ErrGlobalConfigTampered, got %v", err)
+	}
+
+	// a missing mac key is never recreated by SetGlobalConfig
+	if err := s.Delete(globalConfigMACKey); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetGlobalConfig(s); err != ErrGlobalConfigTampered {
+		t.Errorf("expected ErrGlobalConfigTampered without a mac key, got %v", err)
+	}
+	if err := SetGlobalConfig(s, cfg); err != ErrGlobalConfigTampered {
+		t.Errorf("expected ErrGlobalConfigTampered setting a config without a mac key, got %v", err)
+	}
+	if err := ResetGlobalConfig(s, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetGlobalConfig(s); err != nil {
+		t.Errorf("expected a reset config to verify: %v", err)
+	}
+
+	// a config that predates the mac is signed on first read
+	cfg.MAC = nil
+	if _, err := s.Set(globalConfigKey, cfg.ToJSON()); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(globalConfigMACKey); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetGlobalConfig(s); err != nil {
+		t.Errorf("expected a config without a mac to be signed: %v", err)
+	}
+	if key, err := s.Get(globalConfigMACKey); err != nil || len(key) != globalConfigMACLength {
+		t.Errorf("expected a mac key to be created: %v", err)
+	}
+	if cfg, err = GetGlobalConfig(s); err != nil || len(cfg.MAC) == 0 {
+		t.Errorf("expected a signed config: %v", err)
+	}
+
+	// a missing global-config is not recreated in a store that holds other keys
+	if err := s.Delete(globalConfigKey); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	if s, err = NewStorage(config.NewConfig(), Options{Engine: BoltEngine, FilePath: path}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetGlobalConfig(s); err != ErrGlobalConfigTampered {
+		t.Errorf("expected ErrGlobalConfigTampered without a global config, got %v", err)
+	}
+}
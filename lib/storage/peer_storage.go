@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/nomasters/handshake/lib/tlv"
+)
+
+// tags of the tlv fields of a binary encoded PeerStorage
+const (
+	peerTagType byte = iota + 1
+	peerTagReadNode
+	peerTagWriteNode
+	peerTagReadRule
+	peerTagWriteRule
+	nodeTagURL
+	nodeTagHeader
+	nodeTagSetting
+	entryTagKey
+	entryTagValue
+)
+
+// maxConsensusRule is the largest consensusRule accepted in a binary encoded PeerStorage
+const maxConsensusRule = unanimousSuccess
+
+// EncodeBinary returns the PeerStorage as compact tlv encoded bytes. Zero values are omitted and map entries
+// are sorted by key, so that equal PeerStorage encode to equal bytes.
+func (p PeerStorage) EncodeBinary() []byte {
+	var e tlv.Encoder
+	if p.Type != 0 {
+		e.Uint(peerTagType, uint64(p.Type))
+	}
+	for _, n := range p.ReadNodes {
+		e.Bytes(peerTagReadNode, encodeNode(n))
+	}
+	for _, n := range p.WriteNodes {
+		e.Bytes(peerTagWriteNode, encodeNode(n))
+	}
+	if p.ReadRule != 0 {
+		e.Uint(peerTagReadRule, uint64(p.ReadRule))
+	}
+	if p.WriteRule != 0 {
+		e.Uint(peerTagWriteRule, uint64(p.WriteRule))
+	}
+	return e.Encoded()
+}
+
+// DecodePeerStorage takes bytes encoded with PeerStorage.EncodeBinary and the byte offset they start at in the
+// enclosing share, and returns the PeerStorage and an error. Decoding errors are tlv.Error values.
+func DecodePeerStorage(b []byte, offset int) (PeerStorage, error) {
+	var p PeerStorage
+	fields, err := tlv.Decode(b, offset)
+	if err != nil {
+		return p, err
+	}
+	seen := make(map[byte]bool)
+	for _, f := range fields {
+		if f.Tag != peerTagReadNode && f.Tag != peerTagWriteNode {
+			if seen[f.Tag] {
+				return p, tlv.Error{Offset: f.Offset, Reason: fmt.Sprintf("duplicate tag %v", f.Tag)}
+			}
+			seen[f.Tag] = true
+		}
+		switch f.Tag {
+		case peerTagType:
+			v, err := f.Uint()
+			if err != nil {
+				return p, err
+			}
+			p.Type = Engine(v)
+		case peerTagReadNode, peerTagWriteNode:
+			n, err := decodeNode(f)
+			if err != nil {
+				return p, err
+			}
+			if f.Tag == peerTagReadNode {
+				p.ReadNodes = append(p.ReadNodes, n)
+			} else {
+				p.WriteNodes = append(p.WriteNodes, n)
+			}
+		case peerTagReadRule, peerTagWriteRule:
+			v, err := f.Uint()
+			if err != nil {
+				return p, err
+			}
+			if v > uint64(maxConsensusRule) {
+				return p, tlv.Error{Offset: f.Offset, Reason: fmt.Sprintf("unknown consensus rule: %v", v)}
+			}
+			if f.Tag == peerTagReadRule {
+				p.ReadRule = consensusRule(v)
+			} else {
+				p.WriteRule = consensusRule(v)
+			}
+		default:
+			return p, tlv.Error{Offset: f.Offset, Reason: fmt.Sprintf("unknown tag %v", f.Tag)}
+		}
+	}
+	return p, nil
+}
+
+func encodeNode(n Node) []byte {
+	var e tlv.Encoder
+	if n.URL != "" {
+		e.String(nodeTagURL, n.URL)
+	}
+	for _, k := range sortedKeys(n.Header) {
+		e.Bytes(nodeTagHeader, encodeEntry(k, n.Header[k]))
+	}
+	for _, k := range sortedKeys(n.Settings) {
+		e.Bytes(nodeTagSetting, encodeEntry(k, n.Settings[k]))
+	}
+	return e.Encoded()
+}
+
+func decodeNode(field tlv.Field) (Node, error) {
+	var n Node
+	fields, err := field.Fields()
+	if err != nil {
+		return n, err
+	}
+	for _, f := range fields {
+		switch f.Tag {
+		case nodeTagURL:
+			if n.URL != "" {
+				return n, tlv.Error{Offset: f.Offset, Reason: fmt.Sprintf("duplicate tag %v", f.Tag)}
+			}
+			n.URL = string(f.Value)
+		case nodeTagHeader, nodeTagSetting:
+			k, v, err := decodeEntry(f)
+			if err != nil {
+				return n, err
+			}
+			if f.Tag == nodeTagHeader {
+				if n.Header == nil {
+					n.Header = make(map[string]string)
+				}
+				n.Header[k] = v
+			} else {
+				if n.Settings == nil {
+					n.Settings = make(map[string]string)
+				}
+				n.Settings[k] = v
+			}
+		default:
+			return n, tlv.Error{Offset: f.Offset, Reason: fmt.Sprintf("unknown tag %v", f.Tag)}
+		}
+	}
+	return n, nil
+}
+
+func encodeEntry(k, v string) []byte {
+	var e tlv.Encoder
+	e.String(entryTagKey, k)
+	e.String(entryTagValue, v)
+	return e.Encoded()
+}
+
+func decodeEntry(field tlv.Field) (k, v string, err error) {
+	fields, err := field.Fields()
+	if err != nil {
+		return
+	}
+	if len(fields) != 2 || fields[0].Tag != entryTagKey || fields[1].Tag != entryTagValue {
+		err = tlv.Error{Offset: field.Offset, Reason: "invalid map entry"}
+		return
+	}
+	return string(fields[0].Value), string(fields[1].Value), nil
+}
+
+// sortedKeys returns the keys of m in sorted order
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
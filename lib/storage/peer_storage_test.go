@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nomasters/handshake/lib/tlv"
+)
+
+func TestPeerStorageBinary(t *testing.T) {
+	p := PeerStorage{
+		Type: testEngine,
+		ReadNodes: []Node{
+			{URL: "https://a.example", Header: map[string]string{"b": "2", "a": "1"}},
+			{URL: "https://b.example", Settings: map[string]string{"query_type": "api"}},
+		},
+		WriteNodes: []Node{{URL: "https://c.example"}},
+		ReadRule:   majoritySuccess,
+		WriteRule:  unanimousSuccess,
+	}
+	b := p.EncodeBinary()
+	decoded, err := DecodePeerStorage(b, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, p) {
+		t.Errorf("decoded PeerStorage does not match:\n%+v\n%+v", decoded, p)
+	}
+	if !reflect.DeepEqual(decoded.EncodeBinary(), b) {
+		t.Error("expected PeerStorage to encode deterministically")
+	}
+
+	var e tlv.Encoder
+	e.Uint(peerTagType, uint64(HashmapEngine))
+	e.Uint(peerTagReadRule, uint64(maxConsensusRule)+1)
+	if _, err := DecodePeerStorage(e.Encoded(), 10); err == nil || err.(tlv.Error).Offset != 15 {
+		t.Errorf("expected tlv.Error at byte 15 for an unknown consensus rule, got %v", err)
+	}
+	if _, err := DecodePeerStorage(b[:len(b)-1], 0); err == nil {
+		t.Error("expected an error for truncated input")
+	}
+}
@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/nomasters/handshake/lib/config"
+)
+
+// CustomEngine is the first Engine value reserved for engines registered outside of this package.
+// Engines defined elsewhere should use values at or above CustomEngine to avoid collisions with
+// the engines built into handshake.
+const CustomEngine Engine = 1000
+
+// EngineConstructors holds the functions used to build a Storage for an Engine. A constructor may be
+// nil if the engine does not support being built that way, for example private device storage is
+// never built from a PeerStorage.
+type EngineConstructors struct {
+	// FromOptions builds a Storage from global config and Options, this is used by NewStorage
+	FromOptions func(cfg config.Config, opts Options) (Storage, error)
+	// FromPeer builds a Storage from the PeerStorage shared during a handshake
+	FromPeer func(s PeerStorage) (Storage, error)
+	// FromConfig builds a Storage from the Config stored with a chat strategy
+	FromConfig func(cfg Config) (Storage, error)
+}
+
+// UnknownEngineError is returned when a Storage is requested for an Engine that has not been registered
+type UnknownEngineError struct {
+	Engine Engine
+}
+
+// Error returns the error string for an UnknownEngineError
+func (e UnknownEngineError) Error() string {
+	return fmt.Sprintf("unknown storage engine type: %v", int(e.Engine))
+}
+
+var (
+	enginesMu sync.RWMutex
+	engines   = make(map[Engine]EngineConstructors)
+)
+
+// RegisterEngine makes a storage engine available to NewStorage, NewStorageFromPeer and NewStorageFromConfig
+// under the Engine identifier. This is intended to be called from the init function of the package that
+// implements the engine. If RegisterEngine is called twice for the same Engine it panics.
+func RegisterEngine(e Engine, c EngineConstructors) {
+	enginesMu.Lock()
+	defer enginesMu.Unlock()
+	if _, ok := engines[e]; ok {
+		panic(fmt.Sprintf("storage: RegisterEngine called twice for engine %v", int(e)))
+	}
+	engines[e] = c
+}
+
+// getEngine returns the EngineConstructors for a registered Engine or an UnknownEngineError
+func getEngine(e Engine) (EngineConstructors, error) {
+	enginesMu.RLock()
+	defer enginesMu.RUnlock()
+	c, ok := engines[e]
+	if !ok {
+		return EngineConstructors{}, UnknownEngineError{Engine: e}
+	}
+	return c, nil
+}
+
+func init() {
+	RegisterEngine(BoltEngine, EngineConstructors{
+		FromOptions: func(cfg config.Config, opts Options) (Storage, error) {
+			return newBoltStorage(cfg, opts)
+		},
+	})
+	RegisterEngine(HashmapEngine, EngineConstructors{
+		FromOptions: func(cfg config.Config, opts Options) (Storage, error) {
+			return NewHashmapStorage(opts)
+		},
+		FromPeer: func(s PeerStorage) (Storage, error) {
+			return &HashmapStorage{
+				ReadNodes: s.ReadNodes,
+				ReadRule:  s.ReadRule,
+			}, nil
+		},
+		FromConfig: func(cfg Config) (Storage, error) {
+			return &HashmapStorage{
+				ReadNodes:  cfg.ReadNodes,
+				ReadRule:   cfg.ReadRule,
+				WriteNodes: cfg.WriteNodes,
+				WriteRule:  cfg.WriteRule,
+				Signatures: cfg.Signatures,
+				Latest:     cfg.Latest,
+			}, nil
+		},
+	})
+	RegisterEngine(IPFSEngine, EngineConstructors{
+		FromOptions: func(cfg config.Config, opts Options) (Storage, error) {
+			return NewIPFSStorage(opts)
+		},
+		FromPeer: func(s PeerStorage) (Storage, error) {
+			return IPFSStorage{
+				ReadNodes: s.ReadNodes,
+				ReadRule:  s.ReadRule,
+			}, nil
+		},
+		FromConfig: func(cfg Config) (Storage, error) {
+			return IPFSStorage{
+				ReadNodes:  cfg.ReadNodes,
+				ReadRule:   cfg.ReadRule,
+				WriteNodes: cfg.WriteNodes,
+				WriteRule:  cfg.WriteRule,
+			}, nil
+		},
+	})
+}
@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/nomasters/handshake/lib/config"
+)
+
+const testEngine = CustomEngine + 1
+
+// testStorage is a minimal Storage used to exercise engine registration
+type testStorage struct {
+	nodes []Node
+}
+
+func (s testStorage) Get(key string) ([]byte, error)               { return []byte{}, nil }
+func (s testStorage) Set(key string, value []byte) (string, error) { return key, nil }
+func (s testStorage) Delete(key string) error                      { return nil }
+func (s testStorage) List(path string) ([]string, error)           { return []string{}, nil }
+func (s testStorage) Close() error                                 { return nil }
+func (s testStorage) Export() (Config, error) {
+	return Config{Type: testEngine, WriteNodes: s.nodes}, nil
+}
+func (s testStorage) Share() (PeerStorage, error) {
+	return PeerStorage{Type: testEngine, ReadNodes: s.nodes}, nil
+}
+
+func init() {
+	RegisterEngine(testEngine, EngineConstructors{
+		FromOptions: func(cfg config.Config, opts Options) (Storage, error) {
+			return testStorage{nodes: opts.WriteNodes}, nil
+		},
+		FromPeer: func(s PeerStorage) (Storage, error) {
+			return testStorage{nodes: s.ReadNodes}, nil
+		},
+		FromConfig: func(cfg Config) (Storage, error) {
+			return testStorage{nodes: cfg.WriteNodes}, nil
+		},
+	})
+}
+
+func TestRegisteredEngine(t *testing.T) {
+	opts := Options{
+		Engine:     testEngine,
+		WriteNodes: []Node{{URL: "http://localhost"}},
+	}
+	s, err := NewStorage(config.NewConfig(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peer, err := s.Share()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromPeer, err := NewStorageFromPeer(peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fromPeer.(testStorage).nodes[0].URL != "http://localhost" {
+		t.Error("peer storage did not round trip")
+	}
+
+	cfg, err := s.Export()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromConfig, err := NewStorageFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fromConfig.(testStorage).nodes[0].URL != "http://localhost" {
+		t.Error("config storage did not round trip")
+	}
+}
+
+func TestUnknownEngine(t *testing.T) {
+	_, err := NewStorageFromPeer(PeerStorage{Type: CustomEngine + 99})
+	if _, ok := err.(UnknownEngineError); !ok {
+		t.Errorf("expected UnknownEngineError, got %v", err)
+	}
+	_, err = NewStorageFromConfig(Config{Type: CustomEngine + 99})
+	if _, ok := err.(UnknownEngineError); !ok {
+		t.Errorf("expected UnknownEngineError, got %v", err)
+	}
+	if _, err := NewStorageFromPeer(PeerStorage{Type: BoltEngine}); err == nil {
+		t.Error("expected bolt engine to reject peer configs")
+	}
+}
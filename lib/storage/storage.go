@@ -1,7 +1,7 @@
 package storage
 
 import (
-	"errors"
+	"fmt"
 
 	"github.com/nomasters/hashmap"
 
@@ -29,9 +29,11 @@ const (
 	// DefaultTLB is the name of the top level bucket for BoltDB
 	defaultTLB = "handshake"
 	// GlobalConfigKey is the key string for where global-config is stored
-	globalConfigKey      = "global-config"
-	maxIPFSRead          = 3000000 // ~3MB
-	defaultRendezvousURL = "https://prototype.hashmap.sh"
+	globalConfigKey = "global-config"
+	// globalConfigMACKey is the key string for where the key used to MAC the global-config is stored
+	globalConfigMACKey    = "global-config-mac-key"
+	globalConfigMACLength = 32
+	defaultRendezvousURL  = "https://prototype.hashmap.sh"
 )
 
 type signatureType int
@@ -56,6 +58,29 @@ const (
 	unanimousSuccess
 )
 
+// threshold takes the total number of configured nodes and returns the number of nodes that must
+// succeed for the consensusRule to be satisfied. An error is returned if the rule is unknown or if
+// not enough nodes are configured to ever satisfy it.
+func (r consensusRule) threshold(total int) (int, error) {
+	var required int
+	switch r {
+	case firstSuccess:
+		required = 1
+	case redundantPairSuccess:
+		required = 2
+	case majoritySuccess:
+		required = total/2 + 1
+	case unanimousSuccess:
+		required = total
+	default:
+		return 0, fmt.Errorf("unknown consensus rule: %v", r)
+	}
+	if required > total {
+		return 0, fmt.Errorf("consensus rule requires %v nodes, but only %v are configured", required, total)
+	}
+	return required, nil
+}
+
 const (
 	// DefaultConsensusRule is the default consensusRule
 	DefaultConsensusRule  = firstSuccess
@@ -73,12 +98,48 @@ type Storage interface {
 	Share() (PeerStorage, error)
 }
 
+// ReplicatedStorage is implemented by Storage engines that write to multiple nodes and can report
+// the outcome of a Set for each node it was sent to
+type ReplicatedStorage interface {
+	Storage
+	SetReplicated(key string, value []byte) (string, []NodeResult, error)
+}
+
+// Compactor is implemented by Storage engines that can compact their data store, so that deleted and
+// overwritten values can't be recovered from it
+type Compactor interface {
+	Storage
+	Compact() error
+}
+
+// NodeResult captures the outcome of a Storage operation against a single Node
+type NodeResult struct {
+	URL   string `json:"url"`
+	Hash  string `json:"hash,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// newNodeResult takes a node, a hash, and an error and returns a NodeResult
+func newNodeResult(node Node, hash string, err error) NodeResult {
+	r := NodeResult{URL: node.URL, Hash: hash}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return r
+}
+
 // NewDefaultRendezvous provides the default rendezvous storage location
 func NewDefaultRendezvous() *HashmapStorage {
+	return NewHashmapRendezvous(defaultRendezvousURL)
+}
+
+// NewHashmapRendezvous takes the URL of a hashmap server and provides a rendezvous storage location
+// with a newly generated signing key. This allows a self-hosted hashmap server to be used.
+func NewHashmapRendezvous(url string) *HashmapStorage {
 	privateKey := hashmap.GenerateKey()
 	publicKey := privateKey[32:]
 	n := Node{
-		URL: defaultRendezvousURL,
+		URL: url,
 	}
 	sig := SignatureAlgorithm{
 		Type:       ED25519,
@@ -102,6 +163,12 @@ func NewDefaultMessageStorage() Storage {
 		Settings: settings,
 	}
 
+	return NewIPFSMessageStorage(n)
+}
+
+// NewIPFSMessageStorage takes an IPFS node and provides a long-term storage location that writes to it.
+// This allows a self-hosted IPFS node or gateway to be used.
+func NewIPFSMessageStorage(n Node) Storage {
 	return IPFSStorage{
 		WriteNodes: []Node{n},
 		WriteRule:  DefaultConsensusRule,
@@ -144,56 +211,43 @@ type Options struct {
 	WriteNodes []Node
 	ReadRule   consensusRule
 	WriteRule  consensusRule
+	// SecureDelete compacts device Storage when it is closed, so that deleted and overwritten values,
+	// such as used one-time keys, don't remain in freed pages
+	SecureDelete bool
 }
 
-// NewStorage initiates a new Storage Interface
+// NewStorage initiates a new Storage Interface for the registered engine set in opts
 func NewStorage(cfg config.Config, opts Options) (Storage, error) {
-	switch opts.Engine {
-	case BoltEngine:
-		return newBoltStorage(cfg, opts)
-	default:
-		return nil, errors.New("invalid engine type")
+	c, err := getEngine(opts.Engine)
+	if err != nil {
+		return nil, err
+	}
+	if c.FromOptions == nil {
+		return nil, fmt.Errorf("storage engine %v does not support initialization from options", int(opts.Engine))
 	}
+	return c.FromOptions(cfg, opts)
 }
 
-// NewStorageFromPeer creates a new Storage from a PeerStorage
+// NewStorageFromPeer creates a new Storage from a PeerStorage using the registered engine for its type
 func NewStorageFromPeer(s PeerStorage) (Storage, error) {
-	switch s.Type {
-	case IPFSEngine:
-		return IPFSStorage{
-			ReadNodes: s.ReadNodes,
-			ReadRule:  s.ReadRule,
-		}, nil
-	case HashmapEngine:
-		return &HashmapStorage{
-			ReadNodes: s.ReadNodes,
-			ReadRule:  s.ReadRule,
-		}, nil
-	default:
-		return nil, errors.New("invalid Storage engine type")
+	c, err := getEngine(s.Type)
+	if err != nil {
+		return nil, err
 	}
+	if c.FromPeer == nil {
+		return nil, fmt.Errorf("storage engine %v does not support peer configs", int(s.Type))
+	}
+	return c.FromPeer(s)
 }
 
-// NewStorageFromConfig creates a new Storage from a Config
+// NewStorageFromConfig creates a new Storage from a Config using the registered engine for its type
 func NewStorageFromConfig(cfg Config) (Storage, error) {
-	switch cfg.Type {
-	case IPFSEngine:
-		return IPFSStorage{
-			ReadNodes:  cfg.ReadNodes,
-			ReadRule:   cfg.ReadRule,
-			WriteNodes: cfg.WriteNodes,
-			WriteRule:  cfg.WriteRule,
-		}, nil
-	case HashmapEngine:
-		return &HashmapStorage{
-			ReadNodes:  cfg.ReadNodes,
-			ReadRule:   cfg.ReadRule,
-			WriteNodes: cfg.WriteNodes,
-			WriteRule:  cfg.WriteRule,
-			Signatures: cfg.Signatures,
-			Latest:     cfg.Latest,
-		}, nil
-	default:
-		return nil, errors.New("invalid Storage engine type")
+	c, err := getEngine(cfg.Type)
+	if err != nil {
+		return nil, err
+	}
+	if c.FromConfig == nil {
+		return nil, fmt.Errorf("storage engine %v does not support stored configs", int(cfg.Type))
 	}
+	return c.FromConfig(cfg)
 }
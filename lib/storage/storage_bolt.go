@@ -2,8 +2,10 @@ package storage
 
 import (
 	"bytes"
+	"crypto/rand"
 	"errors"
 	"fmt"
+	"os"
 
 	"github.com/nomasters/handshake/lib/config"
 
@@ -12,7 +14,7 @@ import (
 
 // NewBoltStorage takes Options as an argument and returns a reference to a BoltDB
 // based implementation of the Storage interface.
-func newBoltStorage(cfg config.Config, opts Options) (boltStorage, error) {
+func newBoltStorage(cfg config.Config, opts Options) (*boltStorage, error) {
 	tlb := defaultTLB
 	fp := DefaultBoltFilePath
 	if opts.FilePath != "" {
@@ -20,7 +22,7 @@ func newBoltStorage(cfg config.Config, opts Options) (boltStorage, error) {
 	}
 	db, err := bolt.Open(fp, 0666, nil)
 	if err != nil {
-		return boltStorage{}, err
+		return nil, err
 	}
 
 	// ensure that top level bucket exists
@@ -30,29 +32,44 @@ func newBoltStorage(cfg config.Config, opts Options) (boltStorage, error) {
 		}
 		return nil
 	}); err != nil {
-		return boltStorage{}, err
+		db.Close()
+		return nil, err
 	}
 
-	// ensure that Config exists, and if not, initialize GlobalConfig
+	// initialize GlobalConfig in a new data store. It is never recreated in a store that holds other keys, so
+	// that deleting it can't be used to reset the failed login counter.
 	if err := db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(tlb))
-		blob := b.Get([]byte(globalConfigKey))
-		if blob == nil {
-			return b.Put([]byte(globalConfigKey), cfg.ToJSON())
+		if k, _ := b.Cursor().First(); k == nil {
+			key := make([]byte, globalConfigMACLength)
+			if _, err := rand.Read(key); err != nil {
+				return err
+			}
+			signed, err := cfg.Sign(key)
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(globalConfigMACKey), key); err != nil {
+				return err
+			}
+			return b.Put([]byte(globalConfigKey), signed.ToJSON())
 		}
 		return nil
 	}); err != nil {
-		return boltStorage{}, err
+		db.Close()
+		return nil, err
 	}
 
-	return boltStorage{db: db, tlb: tlb}, nil
+	return &boltStorage{db: db, tlb: tlb, path: fp, secureDelete: opts.SecureDelete}, nil
 }
 
 // BoltStorage is a struct that conforms to the Storage interface for using
 // BoltDB. DB is a reference to a boltDB instance and TLB stands for "top level bucket"
 type boltStorage struct {
-	db  *bolt.DB
-	tlb string
+	db           *bolt.DB
+	tlb          string
+	path         string
+	secureDelete bool
 }
 
 // Get takes a key string and returns a byte slice or error from a BoltStorage struct. Get
@@ -62,7 +79,10 @@ type boltStorage struct {
 func (s boltStorage) Get(key string) (value []byte, err error) {
 	err = s.db.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(s.tlb))
-		value = b.Get([]byte(key))
+		// values returned by bolt are only valid for the life of the transaction, so a copy is returned
+		if v := b.Get([]byte(key)); v != nil {
+			value = append([]byte{}, v...)
+		}
 		return nil
 	})
 	return value, err
@@ -111,7 +131,112 @@ func (s boltStorage) Export() (Config, error) {
 	return Config{}, errors.New("this Storage does not support exporting configs")
 }
 
-// Close is used to close the Bolt DB engine and returns an error
-func (s boltStorage) Close() error {
+// Close is used to close the Bolt DB engine and returns an error. With SecureDelete set, the data store is
+// compacted before it is closed.
+func (s *boltStorage) Close() error {
+	if s.secureDelete {
+		if err := s.Compact(); err != nil {
+			s.db.Close()
+			return err
+		}
+	}
 	return s.db.Close()
 }
+
+// Compact copies the live data in the Bolt DB into a fresh file that replaces the original. The original
+// file, including freed pages holding deleted and overwritten values, is then overwritten with zeros. It
+// must not be called concurrently with other methods. Filesystems that copy on write may still retain
+// the original blocks.
+func (s *boltStorage) Compact() error {
+	tmpPath := s.path + ".compact"
+	os.Remove(tmpPath)
+	dst, err := bolt.Open(tmpPath, 0600, nil)
+	if err != nil {
+		return err
+	}
+	if err := copyBolt(s.db, dst); err != nil {
+		dst.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := s.db.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	err = s.replaceFile(tmpPath)
+	db, openErr := bolt.Open(s.path, 0666, nil)
+	if openErr != nil {
+		return openErr
+	}
+	s.db = db
+	return err
+}
+
+// replaceFile takes the path of a compacted Bolt DB file, renames it over the Bolt DB file, and overwrites
+// the original. The original is held open across the rename so it can be overwritten after it is replaced.
+func (s *boltStorage) replaceFile(compactPath string) error {
+	original, err := os.OpenFile(s.path, os.O_WRONLY, 0)
+	if err != nil {
+		os.Remove(compactPath)
+		return err
+	}
+	defer original.Close()
+	if err := os.Rename(compactPath, s.path); err != nil {
+		os.Remove(compactPath)
+		return err
+	}
+	return overwriteFile(original)
+}
+
+// copyBolt copies all buckets from the src Bolt DB into the dst Bolt DB
+func copyBolt(src, dst *bolt.DB) error {
+	return src.View(func(srcTx *bolt.Tx) error {
+		return dst.Update(func(dstTx *bolt.Tx) error {
+			return srcTx.ForEach(func(name []byte, b *bolt.Bucket) error {
+				dstBucket, err := dstTx.CreateBucket(name)
+				if err != nil {
+					return err
+				}
+				return copyBoltBucket(b, dstBucket)
+			})
+		})
+	})
+}
+
+// copyBoltBucket copies all keys and nested buckets from the src bucket into the dst bucket
+func copyBoltBucket(src, dst *bolt.Bucket) error {
+	return src.ForEach(func(k, v []byte) error {
+		if v == nil {
+			nested, err := dst.CreateBucket(k)
+			if err != nil {
+				return err
+			}
+			return copyBoltBucket(src.Bucket(k), nested)
+		}
+		return dst.Put(k, v)
+	})
+}
+
+// overwriteFile overwrites the contents of a file with zeros and syncs it to disk
+func overwriteFile(f *os.File) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	zeros := make([]byte, 64*1024)
+	for written := int64(0); written < info.Size(); {
+		n := int64(len(zeros))
+		if remaining := info.Size() - written; remaining < n {
+			n = remaining
+		}
+		if _, err := f.WriteAt(zeros[:n], written); err != nil {
+			return err
+		}
+		written += n
+	}
+	return f.Sync()
+}
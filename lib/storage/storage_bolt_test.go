@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/nomasters/handshake/lib/config"
+)
+
+func TestBoltCompact(t *testing.T) {
+	path := "compact.boltdb"
+	os.Remove(path)
+	defer os.Remove(path)
+
+	secret := []byte("consumed-one-time-key-0123456789")
+	kept := []byte("kept-value-0123456789")
+	s, err := NewStorage(config.NewConfig(), Options{Engine: BoltEngine, FilePath: path, SecureDelete: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Set("chats/secret", secret); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Set("chats/kept", kept); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("chats/secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	c, ok := s.(Compactor)
+	if !ok {
+		t.Fatal("bolt storage does not implement Compactor")
+	}
+	if err := c.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(b, secret) {
+		t.Error("deleted value remains in compacted file")
+	}
+	if v, err := s.Get("chats/kept"); err != nil || !bytes.Equal(v, kept) {
+		t.Errorf("expected kept value after compaction, got %q: %v", v, err)
+	}
+	if _, err := GetGlobalConfig(s); err != nil {
+		t.Errorf("global config failed to verify after compaction: %v", err)
+	}
+
+	if _, err := s.Set("chats/secret", secret); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("chats/secret"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if b, err = ioutil.ReadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(b, secret) {
+		t.Error("deleted value remains after Close with SecureDelete")
+	}
+	if _, err := os.Stat(path + ".compact"); !os.IsNotExist(err) {
+		t.Error("compaction file was not removed")
+	}
+}
+
+func TestBoltGetOutlivesTransaction(t *testing.T) {
+	path := "get-copy.boltdb"
+	os.Remove(path)
+	defer os.Remove(path)
+
+	s, err := NewStorage(config.NewConfig(), Options{Engine: BoltEngine, FilePath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	value := []byte("value-0123456789")
+	if _, err := s.Set("key", value); err != nil {
+		t.Fatal(err)
+	}
+	v, err := s.Get("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a large write grows the file and remaps the bolt db, which invalidates slices into the old mapping
+	if _, err := s.Set("large", make([]byte, 4*1024*1024)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(v, value) {
+		t.Errorf("expected %q after a write, got %q", value, v)
+	}
+}
@@ -7,9 +7,12 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/nomasters/hashmap"
+
+	"github.com/nomasters/handshake/lib/endpoint"
 )
 
 // HashmapStorage interacts with a hashmap server and
@@ -64,52 +67,137 @@ func getHashFromPath(path string) string {
 	return path[lastIndex+1:]
 }
 
-// getFirstSuccess loops through all ReadNodes in a hashmapStorage and attempts to resolve the data from a
-// payload. There is an important set of steps that this goes through, including:
+// hashmapResult holds the verified contents of a payload retrieved from a single hashmap node
+type hashmapResult struct {
+	Timestamp int64
+	Message   []byte
+}
+
+// nodeUnavailableError is returned by getFromNode when a node can't be reached or returns no payload.
+// Unlike a failed verification, it doesn't stop getFirstSuccess from trying the next node.
+type nodeUnavailableError struct {
+	err error
+}
+
+func (e nodeUnavailableError) Error() string {
+	return e.err.Error()
+}
+
+// getFromNode attempts to resolve the data from a payload on a single hashmap node. There is an
+// important set of steps that this goes through, including:
 // - validating the MultiHash in the URL is supported
 // - comparing the payload pubkey to the url hash, which must match.
-// if all verification and validations are successful, it returns the data bytes from the payload
+// if all verification and validations are successful, it returns a hashmapResult from the payload
+func getFromNode(node Node) (hashmapResult, error) {
+	u, err := url.Parse(node.URL)
+	if err != nil {
+		return hashmapResult{}, fmt.Errorf("invalid url for: %v", node.URL)
+	}
+	urlHash := getHashFromPath(u.Path)
+	if !endpoint.IsHashmapMultihash(urlHash) {
+		return hashmapResult{}, fmt.Errorf("invalid hashmap endpoint for: %v", node.URL)
+	}
+
+	resp, err := http.Get(node.URL)
+	if err != nil {
+		return hashmapResult{}, nodeUnavailableError{err}
+	}
+	defer resp.Body.Close()
+
+	payload, err := hashmap.NewPayloadFromReader(resp.Body)
+	if err != nil {
+		return hashmapResult{}, nodeUnavailableError{err}
+	}
+
+	pubkey, err := payload.PubKeyBytes()
+	if err != nil {
+		return hashmapResult{}, fmt.Errorf("invalid pubkey in payload for: %v", node.URL)
+	}
+
+	if urlHash != endpoint.Base58Multihash(pubkey) {
+		return hashmapResult{}, fmt.Errorf("payload and endpoint hash mismatch for: %v", node.URL)
+	}
+
+	data, err := payload.GetData()
+	if err != nil {
+		return hashmapResult{}, err
+	}
+	message, err := data.MessageBytes()
+	if err != nil {
+		return hashmapResult{}, err
+	}
+	return hashmapResult{Timestamp: data.Timestamp, Message: message}, nil
+}
+
+// getFirstSuccess loops through all ReadNodes in a hashmapStorage and returns the message bytes from
+// the first node that returns a payload. Nodes that can't be reached are skipped, but an invalid node URL,
+// a payload that fails verification, or a stale timestamp is returned as an error.
 func (s *HashmapStorage) getFirstSuccess() ([]byte, error) {
 	for _, node := range s.ReadNodes {
-		u, err := url.Parse(node.URL)
+		result, err := getFromNode(node)
+		if _, ok := err.(nodeUnavailableError); ok {
+			continue
+		}
 		if err != nil {
-			return []byte{}, fmt.Errorf("invalid url for: %v", node.URL)
+			return []byte{}, err
 		}
-		urlHash := getHashFromPath(u.Path)
-		if !isHashmapMultihash(urlHash) {
-			return []byte{}, fmt.Errorf("invalid hashmap endpoint for: %v", node.URL)
+		if err := s.updateLatest(result.Timestamp); err != nil {
+			return []byte{}, err
 		}
+		return result.Message, nil
+	}
+	return []byte{}, errors.New("no servers available")
+}
 
-		resp, err := http.Get(node.URL)
-		if err != nil {
-			continue
-		}
-		defer resp.Body.Close()
+// getConsensus queries all ReadNodes concurrently and only returns the message bytes if at least the
+// number of nodes required by the ReadRule agree on the same verified payload with the newest timestamp.
+func (s *HashmapStorage) getConsensus() ([]byte, error) {
+	required, err := s.ReadRule.threshold(len(s.ReadNodes))
+	if err != nil {
+		return []byte{}, err
+	}
 
-		payload, err := hashmap.NewPayloadFromReader(resp.Body)
-		if err != nil {
-			continue
-		}
+	results := make([]hashmapResult, len(s.ReadNodes))
+	errs := make([]error, len(s.ReadNodes))
+	var wg sync.WaitGroup
+	for i, node := range s.ReadNodes {
+		wg.Add(1)
+		go func(i int, node Node) {
+			defer wg.Done()
+			results[i], errs[i] = getFromNode(node)
+		}(i, node)
+	}
+	wg.Wait()
 
-		pubkey, err := payload.PubKeyBytes()
-		if err != nil {
-			return []byte{}, fmt.Errorf("invalid pubkey in payload for: %v", node.URL)
+	var newest *hashmapResult
+	for i := range results {
+		if errs[i] != nil {
+			continue
 		}
-
-		if urlHash != base58Multihash(pubkey) {
-			return []byte{}, fmt.Errorf("payload and endpoint hash mismatch for: %v", node.URL)
+		if newest == nil || results[i].Timestamp > newest.Timestamp {
+			newest = &results[i]
 		}
+	}
+	if newest == nil {
+		return []byte{}, errors.New("no servers available")
+	}
 
-		data, err := payload.GetData()
-		if err != nil {
-			return []byte{}, err
+	matches := 0
+	for i, result := range results {
+		if errs[i] != nil {
+			continue
 		}
-		if err := s.updateLatest(data.Timestamp); err != nil {
-			return []byte{}, err
+		if result.Timestamp == newest.Timestamp && bytes.Equal(result.Message, newest.Message) {
+			matches++
 		}
-		return data.MessageBytes()
 	}
-	return []byte{}, errors.New("no servers available")
+	if matches < required {
+		return []byte{}, fmt.Errorf("read consensus failed: %v of %v required nodes agree", matches, required)
+	}
+	if err := s.updateLatest(newest.Timestamp); err != nil {
+		return []byte{}, err
+	}
+	return newest.Message, nil
 }
 
 // Get fetches an item from storage for a given key
@@ -120,19 +208,31 @@ func (s *HashmapStorage) Get(key string) ([]byte, error) {
 	switch s.ReadRule {
 	case firstSuccess:
 		return s.getFirstSuccess()
+	case redundantPairSuccess, majoritySuccess, unanimousSuccess:
+		return s.getConsensus()
 	default:
 		return []byte{}, errors.New("This readRule is not yet implemented")
 	}
 
 }
 
+// postToNode submits a payload to a single hashmap node and returns an error if the request fails
+// or the node responds with an error status code
+func postToNode(node Node, payload []byte) error {
+	resp, err := http.Post(node.URL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode > 399 {
+		return fmt.Errorf("unexpected status code %v from: %v", resp.StatusCode, node.URL)
+	}
+	return nil
+}
+
 func (s *HashmapStorage) setFirstSuccess(payload []byte) error {
 	for _, node := range s.WriteNodes {
-		resp, err := http.Post(node.URL, "application/json", bytes.NewReader(payload))
-		if err != nil {
-			continue
-		}
-		if resp.StatusCode > 399 {
+		if err := postToNode(node, payload); err != nil {
 			continue
 		}
 		return nil
@@ -140,7 +240,38 @@ func (s *HashmapStorage) setFirstSuccess(payload []byte) error {
 	return errors.New("no servers available")
 }
 
-// Set blah
+// setConsensus submits the payload to all WriteNodes concurrently and returns an error if fewer nodes
+// than required by the WriteRule accepted the payload
+func (s *HashmapStorage) setConsensus(payload []byte) error {
+	required, err := s.WriteRule.threshold(len(s.WriteNodes))
+	if err != nil {
+		return err
+	}
+
+	errs := make([]error, len(s.WriteNodes))
+	var wg sync.WaitGroup
+	for i, node := range s.WriteNodes {
+		wg.Add(1)
+		go func(i int, node Node) {
+			defer wg.Done()
+			errs[i] = postToNode(node, payload)
+		}(i, node)
+	}
+	wg.Wait()
+
+	successes := 0
+	for _, err := range errs {
+		if err == nil {
+			successes++
+		}
+	}
+	if successes < required {
+		return fmt.Errorf("write consensus failed: %v of %v required nodes succeeded", successes, required)
+	}
+	return nil
+}
+
+// Set signs the value as a hashmap payload and submits it to the WriteNodes based on the WriteRule
 func (s *HashmapStorage) Set(key string, value []byte) (string, error) {
 	if len(s.WriteNodes) < 1 {
 		return key, errors.New("no write nodes configured")
@@ -156,6 +287,8 @@ func (s *HashmapStorage) Set(key string, value []byte) (string, error) {
 	switch s.WriteRule {
 	case firstSuccess:
 		return key, s.setFirstSuccess(payload)
+	case redundantPairSuccess, majoritySuccess, unanimousSuccess:
+		return key, s.setConsensus(payload)
 	default:
 		return key, errors.New("This writeRule is not yet implemented")
 	}
@@ -174,8 +307,16 @@ func (s HashmapStorage) List(path string) ([]string, error) {
 func (s HashmapStorage) Close() (e error) { return }
 
 // Share returns a PeerStorage and error, it generates read nodes from the write nodes + pubkey
-// it also returns ReadRules based on the WriteRules
+// it also returns ReadRules based on the WriteRules. Storage created from a peer has no write nodes,
+// so its read nodes are shared as is, which lets an initiator relay the configs of all peers.
 func (s HashmapStorage) Share() (PeerStorage, error) {
+	if len(s.WriteNodes) == 0 {
+		return PeerStorage{
+			Type:      HashmapEngine,
+			ReadNodes: s.ReadNodes,
+			ReadRule:  s.ReadRule,
+		}, nil
+	}
 	readNodes, err := s.genReadFromWriteNodes()
 	if err != nil {
 		return PeerStorage{}, err
@@ -208,7 +349,7 @@ func (s HashmapStorage) genReadFromWriteNodes() ([]Node, error) {
 	var readNodes []Node
 	var endpoints []string
 	for _, sig := range s.Signatures {
-		endpoints = append(endpoints, base58Multihash(sig.PublicKey))
+		endpoints = append(endpoints, endpoint.Base58Multihash(sig.PublicKey))
 	}
 	for _, writeNode := range s.WriteNodes {
 		for _, endpoint := range endpoints {
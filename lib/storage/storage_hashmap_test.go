@@ -2,11 +2,22 @@ package storage
 
 import (
 	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/nomasters/hashmap"
+
+	"github.com/nomasters/handshake/lib/endpoint"
+	"github.com/nomasters/handshake/lib/hashmapserver"
 )
 
 func TestHashmapSet(t *testing.T) {
-	// this needs to be refactored to use a mock server
+	server := httptest.NewServer(hashmapserver.New())
+	defer server.Close()
+
 	privateKeyString := "6zjTWCoDkKESjroDj26qrw0/xSU0B14Co/lIZZHhbHUFFt6rMcqyLt21y1PmoPJbokhXrvO4p+zauvk+GuujzA=="
 	privateKey, err := base64.StdEncoding.DecodeString(privateKeyString)
 	if err != nil {
@@ -15,7 +26,7 @@ func TestHashmapSet(t *testing.T) {
 	publicKey := privateKey[32:]
 
 	n := Node{
-		URL: "https://prototype.hashmap.sh",
+		URL: server.URL,
 	}
 
 	sig := SignatureAlgorithm{
@@ -40,12 +51,20 @@ func TestHashmapSet(t *testing.T) {
 }
 
 func TestHashmapStorageGet(t *testing.T) {
-	n := Node{
-		URL: "https://prototype.hashmap.sh/2DrjgbL8QfKRvxU9KtFYFdNiPZrQijyxkvWXH17QnvNmzB3apR",
-		//    2Drjgb5DseoVAvRLngcVmd4YfJAi3J1145kiNFV3CL32Hs6vzb
+	server := httptest.NewServer(hashmapserver.New())
+	defer server.Close()
+
+	writer := NewHashmapRendezvous(server.URL)
+	if _, err := writer.Set("", []byte("hello, world")); err != nil {
+		t.Fatal(err)
+	}
+	peer, err := writer.Share()
+	if err != nil {
+		t.Fatal(err)
 	}
+
 	opts := Options{
-		ReadNodes: []Node{n},
+		ReadNodes: peer.ReadNodes,
 		ReadRule:  DefaultConsensusRule,
 	}
 	hms, err := NewHashmapStorage(opts)
@@ -56,5 +75,160 @@ func TestHashmapStorageGet(t *testing.T) {
 	if err != nil {
 		t.Errorf("response failed: %v\n", err)
 	}
-	t.Log(string(response))
+	if string(response) != "hello, world" {
+		t.Errorf("expected hello, world, got %v", string(response))
+	}
+}
+
+// newPayloadServer returns a test server that responds to every request with the given payload
+func newPayloadServer(payload []byte) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(payload)
+	}))
+}
+
+// newStatusServer returns a test server that responds to every request with the given status code
+func newStatusServer(code int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(code)
+	}))
+}
+
+func TestConsensusRuleThreshold(t *testing.T) {
+	tests := []struct {
+		rule     consensusRule
+		total    int
+		required int
+		fail     bool
+	}{
+		{firstSuccess, 3, 1, false},
+		{redundantPairSuccess, 3, 2, false},
+		{redundantPairSuccess, 1, 0, true},
+		{majoritySuccess, 3, 2, false},
+		{majoritySuccess, 4, 3, false},
+		{unanimousSuccess, 4, 4, false},
+		{consensusRule(99), 4, 0, true},
+	}
+	for _, test := range tests {
+		required, err := test.rule.threshold(test.total)
+		if test.fail {
+			if err == nil {
+				t.Errorf("expected error for rule %v with %v nodes", test.rule, test.total)
+			}
+			continue
+		}
+		if err != nil {
+			t.Error(err)
+		}
+		if required != test.required {
+			t.Errorf("rule %v with %v nodes: expected %v, got %v", test.rule, test.total, test.required, required)
+		}
+	}
+}
+
+func TestHashmapStorageGetConsensus(t *testing.T) {
+	privateKey := hashmap.GenerateKey()
+	path := endpoint.Base58Multihash(privateKey[32:])
+
+	old, err := hashmap.GeneratePayload(hashmap.GeneratePayloadOptions{Message: "old"}, privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	current, err := hashmap.GeneratePayload(hashmap.GeneratePayloadOptions{Message: "current"}, privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var nodes []Node
+	for _, payload := range [][]byte{current, current, old} {
+		server := newPayloadServer(payload)
+		defer server.Close()
+		nodes = append(nodes, Node{URL: server.URL + "/" + path})
+	}
+
+	for _, rule := range []consensusRule{redundantPairSuccess, majoritySuccess} {
+		hms := &HashmapStorage{ReadNodes: nodes, ReadRule: rule}
+		response, err := hms.Get("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(response) != "current" {
+			t.Errorf("expected current, got %v", string(response))
+		}
+	}
+
+	hms := &HashmapStorage{ReadNodes: nodes, ReadRule: unanimousSuccess}
+	if _, err := hms.Get(""); err == nil {
+		t.Error("expected unanimous read with a lagging node to fail")
+	}
+}
+
+func TestHashmapStorageGetFirstSuccessErrors(t *testing.T) {
+	privateKey := hashmap.GenerateKey()
+	payload, err := hashmap.GeneratePayload(hashmap.GeneratePayloadOptions{Message: "hello"}, privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newPayloadServer(payload)
+	defer server.Close()
+	unavailable := newStatusServer(500)
+	defer unavailable.Close()
+	path := endpoint.Base58Multihash(privateKey[32:])
+	other := endpoint.Base58Multihash(hashmap.GenerateKey()[32:])
+
+	// an unavailable node is skipped
+	hms := &HashmapStorage{ReadNodes: []Node{{URL: unavailable.URL + "/" + path}, {URL: server.URL + "/" + path}}}
+	if response, err := hms.Get(""); err != nil || string(response) != "hello" {
+		t.Errorf("expected hello, got %v: %v", string(response), err)
+	}
+
+	// a payload that doesn't match its endpoint is an error, even if a later node would succeed
+	hms = &HashmapStorage{ReadNodes: []Node{{URL: server.URL + "/" + other}, {URL: server.URL + "/" + path}}}
+	if _, err := hms.Get(""); err == nil || !strings.Contains(err.Error(), "mismatch") {
+		t.Errorf("expected a hash mismatch error, got %v", err)
+	}
+	hms = &HashmapStorage{ReadNodes: []Node{{URL: server.URL + "/invalid"}}}
+	if _, err := hms.Get(""); err == nil || !strings.Contains(err.Error(), "invalid hashmap endpoint") {
+		t.Errorf("expected an invalid endpoint error, got %v", err)
+	}
+}
+
+func TestHashmapStorageSetConsensus(t *testing.T) {
+	privateKey := hashmap.GenerateKey()
+	sig := SignatureAlgorithm{
+		Type:       ED25519,
+		PrivateKey: privateKey,
+		PublicKey:  privateKey[32:],
+	}
+
+	var nodes []Node
+	for _, code := range []int{http.StatusOK, http.StatusOK, http.StatusInternalServerError} {
+		server := newStatusServer(code)
+		defer server.Close()
+		nodes = append(nodes, Node{URL: server.URL})
+	}
+
+	tests := []struct {
+		rule consensusRule
+		fail bool
+	}{
+		{redundantPairSuccess, false},
+		{majoritySuccess, false},
+		{unanimousSuccess, true},
+	}
+	for _, test := range tests {
+		hms := &HashmapStorage{
+			WriteNodes: nodes,
+			Signatures: []SignatureAlgorithm{sig},
+			WriteRule:  test.rule,
+		}
+		_, err := hms.Set("", []byte("hello, world"))
+		if test.fail && err == nil {
+			t.Errorf("expected rule %v to fail", test.rule)
+		}
+		if !test.fail && err != nil {
+			t.Errorf("rule %v failed: %v", test.rule, err)
+		}
+	}
 }
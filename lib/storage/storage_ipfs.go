@@ -12,6 +12,21 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
+
+	"github.com/nomasters/handshake/lib/unixfs"
+)
+
+// MaxIPFSObjectSize is the largest object read from or written to IPFS. Objects are verified as a single block
+// file, so they are limited to one IPFS chunk. Larger objects are rejected with ErrObjectTooLarge rather than
+// split into a multi-block DAG that could not be verified.
+const MaxIPFSObjectSize = unixfs.ChunkSize
+
+var (
+	// ErrObjectTooLarge is returned for an object larger than MaxIPFSObjectSize
+	ErrObjectTooLarge = errors.New("object too large")
+	// ErrHashMismatch is returned when the bytes returned by an IPFS node do not match the requested hash
+	ErrHashMismatch = errors.New("content does not match requested hash")
 )
 
 // IPFSStorage interacts with an IPFS gateway and conforms to the Storage interface
@@ -43,15 +58,110 @@ func (s *IPFSStorage) getFirstSuccess(hash string) ([]byte, error) {
 	return []byte{}, errors.New("no servers available")
 }
 
-func (s IPFSStorage) setFirstSuccess(body []byte) (string, error) {
+// getConsensus fetches the hash from all ReadNodes concurrently and returns the most common response
+// only if at least the number of nodes required by the ReadRule returned identical bytes
+func (s IPFSStorage) getConsensus(hash string) ([]byte, error) {
+	required, err := s.ReadRule.threshold(len(s.ReadNodes))
+	if err != nil {
+		return []byte{}, err
+	}
+
+	results := make([][]byte, len(s.ReadNodes))
+	errs := make([]error, len(s.ReadNodes))
+	var wg sync.WaitGroup
+	for i, node := range s.ReadNodes {
+		wg.Add(1)
+		go func(i int, node Node) {
+			defer wg.Done()
+			results[i], errs[i] = getFromIPFS(node, hash)
+		}(i, node)
+	}
+	wg.Wait()
+
+	var best []byte
+	bestCount := 0
+	for i := range results {
+		if errs[i] != nil {
+			continue
+		}
+		count := 0
+		for j := range results {
+			if errs[j] == nil && bytes.Equal(results[i], results[j]) {
+				count++
+			}
+		}
+		if count > bestCount {
+			best, bestCount = results[i], count
+		}
+	}
+	if bestCount == 0 {
+		return []byte{}, errors.New("no servers available")
+	}
+	if bestCount < required {
+		return []byte{}, fmt.Errorf("read consensus failed: %v of %v required nodes agree", bestCount, required)
+	}
+	return best, nil
+}
+
+func (s IPFSStorage) setFirstSuccess(body []byte) (string, []NodeResult, error) {
+	var results []NodeResult
 	for _, node := range s.WriteNodes {
-		resp, err := postToIPFS(node, body)
+		hash, err := postToIPFS(node, body)
+		results = append(results, newNodeResult(node, hash, err))
 		if err != nil {
 			continue
 		}
-		return resp, nil
+		return hash, results, nil
+	}
+	return "", results, errors.New("no servers available")
+}
+
+// setConsensus adds the body to all WriteNodes concurrently. Once every node has responded, nodes returning a
+// hash other than the one returned by the most nodes are counted as failed. It returns an error if fewer nodes
+// than required by the WriteRule agree on the hash, or if more than one hash has enough nodes.
+func (s IPFSStorage) setConsensus(body []byte) (string, []NodeResult, error) {
+	results := make([]NodeResult, len(s.WriteNodes))
+	required, err := s.WriteRule.threshold(len(s.WriteNodes))
+	if err != nil {
+		return "", results, err
+	}
+
+	var wg sync.WaitGroup
+	for i, node := range s.WriteNodes {
+		wg.Add(1)
+		go func(i int, node Node) {
+			defer wg.Done()
+			hash, err := postToIPFS(node, body)
+			results[i] = newNodeResult(node, hash, err)
+		}(i, node)
+	}
+	wg.Wait()
+
+	counts := make(map[string]int)
+	var hash string
+	for _, result := range results {
+		if result.Error != "" {
+			continue
+		}
+		counts[result.Hash]++
+		if counts[result.Hash] > counts[hash] {
+			hash = result.Hash
+		}
+	}
+	for h, count := range counts {
+		if h != hash && count >= required {
+			return "", results, fmt.Errorf("write consensus failed: hash mismatch between nodes: %v and %v", hash, h)
+		}
+	}
+	for i, result := range results {
+		if result.Error == "" && result.Hash != hash {
+			results[i].Error = fmt.Sprintf("hash mismatch: expected %v, got %v", hash, result.Hash)
+		}
+	}
+	if counts[hash] < required {
+		return "", results, fmt.Errorf("write consensus failed: %v of %v required nodes succeeded", counts[hash], required)
 	}
-	return "", errors.New("no servers available")
+	return hash, results, nil
 }
 
 // Get fetches the value for a given key
@@ -62,6 +172,8 @@ func (s IPFSStorage) Get(key string) ([]byte, error) {
 	switch s.ReadRule {
 	case firstSuccess:
 		return s.getFirstSuccess(key)
+	case redundantPairSuccess, majoritySuccess, unanimousSuccess:
+		return s.getConsensus(key)
 	default:
 		return []byte{}, errors.New("This readRule is not yet implemented")
 	}
@@ -69,14 +181,26 @@ func (s IPFSStorage) Get(key string) ([]byte, error) {
 
 // Set sets the value of a given key to a given value
 func (s IPFSStorage) Set(key string, value []byte) (string, error) {
+	hash, _, err := s.SetReplicated(key, value)
+	return hash, err
+}
+
+// SetReplicated sets the value of a given key to a given value and returns the hash along with
+// the outcome for each node the value was sent to
+func (s IPFSStorage) SetReplicated(key string, value []byte) (string, []NodeResult, error) {
 	if len(s.WriteNodes) < 1 {
-		return "", errors.New("no write nodes configured")
+		return "", []NodeResult{}, errors.New("no write nodes configured")
+	}
+	if len(value) > MaxIPFSObjectSize {
+		return "", []NodeResult{}, ErrObjectTooLarge
 	}
 	switch s.WriteRule {
 	case firstSuccess:
 		return s.setFirstSuccess(value)
+	case redundantPairSuccess, majoritySuccess, unanimousSuccess:
+		return s.setConsensus(value)
 	default:
-		return "", errors.New("This writeRule is not yet implemented")
+		return "", []NodeResult{}, errors.New("This writeRule is not yet implemented")
 	}
 }
 
@@ -89,8 +213,16 @@ func (s IPFSStorage) List(path string) ([]string, error) { return []string{}, ni
 // Close is noop
 func (s IPFSStorage) Close() error { return nil }
 
-// Share generates a PeerStorage from the configured IPFSStorage
+// Share generates a PeerStorage from the configured IPFSStorage. Storage created from a peer has no
+// write nodes, so its read nodes are shared as is.
 func (s IPFSStorage) Share() (PeerStorage, error) {
+	if len(s.WriteNodes) == 0 {
+		return PeerStorage{
+			Type:      IPFSEngine,
+			ReadNodes: s.ReadNodes,
+			ReadRule:  s.ReadRule,
+		}, nil
+	}
 	return PeerStorage{
 		Type:      IPFSEngine,
 		ReadNodes: s.WriteNodes,
@@ -123,6 +255,8 @@ func appendToPath(base, add string) string {
 	return fmt.Sprintf("%s/%s", base, add)
 }
 
+// getFromIPFS fetches the object for a hash from an IPFS node. The returned bytes are verified against
+// the hash, so a node returning anything other than the requested object results in an error.
 func getFromIPFS(n Node, hash string) ([]byte, error) {
 	client := http.DefaultClient
 	u, err := url.Parse(n.URL)
@@ -152,14 +286,32 @@ func getFromIPFS(n Node, hash string) ([]byte, error) {
 	}
 
 	resp, err := client.Do(req)
+	if err != nil {
+		return []byte{}, err
+	}
 	defer func() {
 		if err := resp.Body.Close(); err != nil {
 			log.Printf("error closing response body: %v\n", err)
 		}
 	}()
 
-	limitedReader := &io.LimitedReader{R: resp.Body, N: maxIPFSRead}
-	return ioutil.ReadAll(limitedReader)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return []byte{}, fmt.Errorf("unexpected status code %v from: %v", resp.StatusCode, n.URL)
+	}
+
+	// read one byte past the limit so that oversized objects are rejected instead of truncated
+	limitedReader := &io.LimitedReader{R: resp.Body, N: MaxIPFSObjectSize + 1}
+	b, err := ioutil.ReadAll(limitedReader)
+	if err != nil {
+		return []byte{}, err
+	}
+	if len(b) > MaxIPFSObjectSize {
+		return []byte{}, ErrObjectTooLarge
+	}
+	if err := verifyIPFSMultihash(hash, b); err != nil {
+		return []byte{}, err
+	}
+	return b, nil
 }
 
 func postToIPFS(n Node, body []byte) (string, error) {
@@ -206,6 +358,9 @@ func postToIPFS(n Node, body []byte) (string, error) {
 		if err := json.Unmarshal(body, &output); err != nil {
 			return "", err
 		}
+		if output["Hash"] == "" {
+			return "", fmt.Errorf("no hash returned from: %v", n.URL)
+		}
 		return output["Hash"], nil
 	default:
 		endpoint := "ipfs/"
@@ -223,6 +378,14 @@ func postToIPFS(n Node, body []byte) (string, error) {
 		if err != nil {
 			return "", err
 		}
-		return resp.Header.Get("Ipfs-Hash"), nil
+		defer resp.Body.Close()
+		if resp.StatusCode > 399 {
+			return "", fmt.Errorf("unexpected status code %v from: %v", resp.StatusCode, n.URL)
+		}
+		hash := resp.Header.Get("Ipfs-Hash")
+		if hash == "" {
+			return "", fmt.Errorf("no hash returned from: %v", n.URL)
+		}
+		return hash, nil
 	}
 }
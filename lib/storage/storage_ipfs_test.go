@@ -1,60 +1,227 @@
 package storage
 
 import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+
+	"github.com/nomasters/handshake/lib/ipfsserver"
 )
 
-func TestGetFromIPFS(t *testing.T) {
+// newIPFSTestNodes starts a local ipfs server and returns a node for each query type it supports
+func newIPFSTestNodes() (*httptest.Server, []Node) {
+	server := httptest.NewServer(ipfsserver.New())
 	settings := make(map[string]string)
 	settings["query_type"] = "api"
-
-	happyNodes := []Node{
-		// Node{
-		// 	URL:      "http://127.0.0.1:5001",
-		// 	Settings: settings,
-		// },
+	nodes := []Node{
 		{
-			URL:      "https://ipfs.infura.io:5001/",
+			URL:      server.URL,
 			Settings: settings,
 		},
 		{
-			URL: "https://cloudflare-ipfs.com",
+			URL: server.URL,
 		},
 	}
-	hash := "QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN"
-	for _, n := range happyNodes {
+	return server, nodes
+}
+
+func TestGetFromIPFS(t *testing.T) {
+	server, nodes := newIPFSTestNodes()
+	defer server.Close()
+
+	body := []byte("hello, world")
+	hash, err := postToIPFS(nodes[0], body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range nodes {
 		resp, err := getFromIPFS(n, hash)
 		if err != nil {
 			t.Error(err)
 		}
-		t.Log(string(resp))
+		if !bytes.Equal(resp, body) {
+			t.Errorf("expected %v, got %v", string(body), string(resp))
+		}
 	}
 }
 
 func TestPostToIPFS(t *testing.T) {
-	settings := make(map[string]string)
+	server, nodes := newIPFSTestNodes()
+	defer server.Close()
 
-	settings["query_type"] = "api"
-
-	happyNodes := []Node{
-		// Node{
-		// 	URL:      "http://127.0.0.1:5001",
-		// 	Settings: settings,
-		// },
-		{
-			URL:      "https://ipfs.infura.io:5001/",
-			Settings: settings,
-		},
-		{
-			URL: "https://hardbin.com",
-		},
-	}
 	body := []byte("hello, world")
-	for _, n := range happyNodes {
+	for _, n := range nodes {
 		resp, err := postToIPFS(n, body)
 		if err != nil {
 			t.Error(err)
 		}
-		t.Log(string(resp))
+		if resp != ipfsMultihash(body) {
+			t.Errorf("expected %v, got %v", ipfsMultihash(body), resp)
+		}
+	}
+}
+
+func TestIPFSStorage(t *testing.T) {
+	server, nodes := newIPFSTestNodes()
+	defer server.Close()
+
+	for _, n := range nodes {
+		writer := NewIPFSMessageStorage(n)
+		hash, err := writer.Set("", []byte("hello, world"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		peer, err := writer.Share()
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader, err := NewStorageFromPeer(peer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := reader.Get(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(resp) != "hello, world" {
+			t.Errorf("expected hello, world, got %v", string(resp))
+		}
+	}
+}
+
+// newGatewayServer returns a test server that mimics a writable IPFS gateway. It returns the given hash
+// for every write and the given body for every read.
+func newGatewayServer(hash string, body []byte) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			w.Header().Set("Ipfs-Hash", hash)
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		w.Write(body)
+	}))
+}
+
+func TestIPFSStorageSetConsensus(t *testing.T) {
+	var agreeing []Node
+	for i := 0; i < 3; i++ {
+		server := newGatewayServer("QmAgree", nil)
+		defer server.Close()
+		agreeing = append(agreeing, Node{URL: server.URL})
+	}
+	s := IPFSStorage{WriteNodes: agreeing, WriteRule: unanimousSuccess}
+	hash, results, err := s.SetReplicated("", []byte("hello, world"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != "QmAgree" {
+		t.Errorf("expected QmAgree, got %v", hash)
+	}
+	if len(results) != len(agreeing) {
+		t.Errorf("expected %v results, got %v", len(agreeing), len(results))
+	}
+
+	failing := newStatusServer(http.StatusInternalServerError)
+	defer failing.Close()
+	s.WriteNodes = append(agreeing[:2], Node{URL: failing.URL})
+	if _, err := s.Set("", []byte("hello, world")); err == nil {
+		t.Error("expected unanimous write with a failing node to fail")
+	}
+	s.WriteRule = majoritySuccess
+	if _, err := s.Set("", []byte("hello, world")); err != nil {
+		t.Errorf("majority write failed: %v", err)
+	}
+
+	mismatched := newGatewayServer("QmOther", nil)
+	defer mismatched.Close()
+	// a node returning a different hash counts as a failed node
+	s.WriteNodes = append(agreeing[:2], Node{URL: mismatched.URL})
+	hash, results, err = s.SetReplicated("", []byte("hello, world"))
+	if err != nil || hash != "QmAgree" {
+		t.Errorf("expected majority write with a mismatched node to succeed with QmAgree, got %v: %v", hash, err)
+	}
+	if results[2].Error == "" {
+		t.Error("expected the mismatched node to be reported as failed")
+	}
+	s.WriteRule = unanimousSuccess
+	if _, err := s.Set("", []byte("hello, world")); err == nil {
+		t.Error("expected unanimous write with mismatched hashes to fail")
+	}
+	s.WriteRule = redundantPairSuccess
+	other := newGatewayServer("QmOther", nil)
+	defer other.Close()
+	s.WriteNodes = append(agreeing[:2], Node{URL: mismatched.URL}, Node{URL: other.URL})
+	if _, err := s.Set("", []byte("hello, world")); err == nil {
+		t.Error("expected write with two hashes reaching the threshold to fail")
+	}
+}
+
+func TestIPFSStorageGetConsensus(t *testing.T) {
+	hash := ipfsMultihash([]byte("hello, world"))
+	var nodes []Node
+	for _, body := range []string{"hello, world", "hello, world", "tampered"} {
+		server := newGatewayServer("", []byte(body))
+		defer server.Close()
+		nodes = append(nodes, Node{URL: server.URL})
+	}
+	s := IPFSStorage{ReadNodes: nodes, ReadRule: majoritySuccess}
+	resp, err := s.Get(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp) != "hello, world" {
+		t.Errorf("expected hello, world, got %v", string(resp))
+	}
+	s.ReadRule = unanimousSuccess
+	if _, err := s.Get(hash); err == nil {
+		t.Error("expected unanimous read with a tampered node to fail")
+	}
+}
+
+func TestGetFromIPFSVerification(t *testing.T) {
+	body := []byte("hello world\n")
+	hash := "QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o"
+	if h := ipfsMultihash(body); h != hash {
+		t.Fatalf("expected %v, got %v", hash, h)
+	}
+
+	server := newGatewayServer("", body)
+	defer server.Close()
+	resp, err := getFromIPFS(Node{URL: server.URL}, hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(resp, body) {
+		t.Errorf("expected %v, got %v", string(body), string(resp))
+	}
+
+	tampered := newGatewayServer("", []byte("<html>not found</html>"))
+	defer tampered.Close()
+	if _, err := getFromIPFS(Node{URL: tampered.URL}, hash); err != ErrHashMismatch {
+		t.Errorf("expected ErrHashMismatch, got %v", err)
+	}
+
+	notFound := newStatusServer(http.StatusNotFound)
+	defer notFound.Close()
+	if _, err := getFromIPFS(Node{URL: notFound.URL}, hash); err == nil {
+		t.Error("expected error for non-2xx response")
+	}
+
+	// objects are verified as a single chunk, so reads are limited to exactly one chunk
+	chunk := make([]byte, MaxIPFSObjectSize)
+	full := newGatewayServer("", chunk)
+	defer full.Close()
+	if resp, err := getFromIPFS(Node{URL: full.URL}, ipfsMultihash(chunk)); err != nil || len(resp) != len(chunk) {
+		t.Errorf("expected a full chunk to verify: %v", err)
+	}
+	large := newGatewayServer("", append(chunk, 0))
+	defer large.Close()
+	if _, err := getFromIPFS(Node{URL: large.URL}, ipfsMultihash(append(chunk, 0))); err != ErrObjectTooLarge {
+		t.Errorf("expected ErrObjectTooLarge, got %v", err)
+	}
+	s := IPFSStorage{WriteNodes: []Node{{URL: full.URL}}}
+	if _, err := s.Set("", append(chunk, 0)); err != ErrObjectTooLarge {
+		t.Errorf("expected ErrObjectTooLarge writing more than a chunk, got %v", err)
 	}
 }
@@ -0,0 +1,92 @@
+// Package tlv implements the tag-length-value encoding used for compact handshake shares. A field is a
+// one byte tag, the uvarint length of its value, and the value. Integers are stored as uvarints and nested
+// values are themselves tlv encoded.
+package tlv
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Error is returned when tlv encoded bytes can't be decoded
+type Error struct {
+	// Offset is the byte offset at which decoding failed
+	Offset int
+	// Reason describes why decoding failed
+	Reason string
+}
+
+// Error returns the error string for an Error
+func (e Error) Error() string {
+	return fmt.Sprintf("%v at byte %v", e.Reason, e.Offset)
+}
+
+// Field is a decoded tlv field
+type Field struct {
+	Tag   byte
+	Value []byte
+	// Offset is the byte offset of the value in the outermost encoded bytes
+	Offset int
+}
+
+// Encoder appends tlv encoded fields to a byte slice
+type Encoder struct {
+	b []byte
+}
+
+// Bytes appends a field with the tag and value
+func (e *Encoder) Bytes(tag byte, value []byte) {
+	e.b = append(e.b, tag)
+	e.b = binary.AppendUvarint(e.b, uint64(len(value)))
+	e.b = append(e.b, value...)
+}
+
+// String appends a field with the tag and string value
+func (e *Encoder) String(tag byte, value string) {
+	e.Bytes(tag, []byte(value))
+}
+
+// Uint appends a field with the tag and the value encoded as a uvarint
+func (e *Encoder) Uint(tag byte, value uint64) {
+	e.Bytes(tag, binary.AppendUvarint(nil, value))
+}
+
+// Encoded returns the encoded fields
+func (e *Encoder) Encoded() []byte {
+	return e.b
+}
+
+// Decode takes tlv encoded bytes and the byte offset they start at in the outermost encoded bytes, and returns
+// the fields in order and an error
+func Decode(b []byte, offset int) ([]Field, error) {
+	var fields []Field
+	for i := 0; i < len(b); {
+		tag := b[i]
+		length, n := binary.Uvarint(b[i+1:])
+		if n <= 0 {
+			return fields, Error{Offset: offset + i + 1, Reason: fmt.Sprintf("invalid length for tag %v", tag)}
+		}
+		start := i + 1 + n
+		if length > uint64(len(b)-start) {
+			return fields, Error{Offset: offset + i + 1, Reason: fmt.Sprintf("length of tag %v exceeds input", tag)}
+		}
+		end := start + int(length)
+		fields = append(fields, Field{Tag: tag, Value: b[start:end], Offset: offset + start})
+		i = end
+	}
+	return fields, nil
+}
+
+// Uint returns the value of the field decoded as a uvarint and an error
+func (f Field) Uint() (uint64, error) {
+	v, n := binary.Uvarint(f.Value)
+	if n <= 0 || n != len(f.Value) {
+		return 0, Error{Offset: f.Offset, Reason: fmt.Sprintf("invalid integer for tag %v", f.Tag)}
+	}
+	return v, nil
+}
+
+// Fields returns the nested fields of the value and an error
+func (f Field) Fields() ([]Field, error) {
+	return Decode(f.Value, f.Offset)
+}
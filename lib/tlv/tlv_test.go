@@ -0,0 +1,41 @@
+package tlv
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	var nested Encoder
+	nested.Uint(3, 300)
+	var e Encoder
+	e.String(1, "alias")
+	e.Bytes(2, nested.Encoded())
+	b := e.Encoded()
+
+	fields, err := Decode(b, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fields) != 2 || string(fields[0].Value) != "alias" || fields[0].Offset != 7 {
+		t.Fatalf("unexpected fields: %+v", fields)
+	}
+	inner, err := fields[1].Fields()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := inner[0].Uint(); err != nil || v != 300 || inner[0].Offset != 16 {
+		t.Errorf("expected 300 at byte 16, got %v at byte %v: %v", v, inner[0].Offset, err)
+	}
+	if !bytes.Equal(b[inner[0].Offset-5:], inner[0].Value) {
+		t.Error("offset does not point at the value")
+	}
+
+	_, err = Decode(b[:len(b)-1], 0)
+	if e, ok := err.(Error); !ok || e.Offset != 8 {
+		t.Errorf("expected Error at byte 8 for truncated input, got %v", err)
+	}
+	if _, err := (Field{Value: []byte{0x80}}).Uint(); err == nil {
+		t.Error("expected an error for an invalid integer")
+	}
+}
@@ -0,0 +1,54 @@
+package unixfs
+
+import (
+	"encoding/binary"
+
+	multihash "github.com/multiformats/go-multihash"
+)
+
+const (
+	// ChunkSize is the default IPFS chunk size in bytes. Files larger than this are split into multiple
+	// blocks by IPFS and cannot be hashed with FileMultihash.
+	ChunkSize = 262144
+	// fileType is the UnixFS Data type enum for a file
+	fileType = 2
+)
+
+// FileMultihash takes a set of bytes and returns the base58 encoded CIDv0 that an IPFS node would
+// generate when adding the bytes as a single block file. The bytes are wrapped in a UnixFS file node
+// inside of a dag-pb node, and the protobuf encoding is hashed with sha2-256. This only matches IPFS
+// for files that fit in a single chunk, which covers all handshake payloads.
+func FileMultihash(b []byte) string {
+	var data []byte
+	data = appendProtoVarint(data, 1, fileType)
+	// IPFS omits the Data field of an empty file
+	if len(b) > 0 {
+		data = appendProtoBytes(data, 2, b)
+	}
+	data = appendProtoVarint(data, 3, uint64(len(b)))
+
+	var node []byte
+	node = appendProtoBytes(node, 1, data)
+	mh, _ := multihash.Sum(node, multihash.SHA2_256, -1)
+	return mh.B58String()
+}
+
+// appendProtoVarint appends a protobuf varint field to b and returns the result
+func appendProtoVarint(b []byte, field int, v uint64) []byte {
+	b = appendUvarint(b, uint64(field<<3))
+	return appendUvarint(b, v)
+}
+
+// appendProtoBytes appends a protobuf length delimited field to b and returns the result
+func appendProtoBytes(b []byte, field int, v []byte) []byte {
+	b = appendUvarint(b, uint64(field<<3|2))
+	b = appendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// appendUvarint appends the varint encoding of v to b and returns the result
+func appendUvarint(b []byte, v uint64) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(buf, v)
+	return append(b, buf[:n]...)
+}
@@ -0,0 +1,19 @@
+package unixfs
+
+import "testing"
+
+func TestFileMultihash(t *testing.T) {
+	// the well known hash of `echo "hello world" | ipfs add`
+	expected := "QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o"
+	if hash := FileMultihash([]byte("hello world\n")); hash != expected {
+		t.Errorf("expected %v, got %v", expected, hash)
+	}
+}
+
+func TestFileMultihashEmpty(t *testing.T) {
+	// the well known hash of an empty file added with `ipfs add`
+	expected := "QmbFMke1KXqnYyBBWxB74N4c5SBnJMVAiMNRcGu6x1AwQH"
+	if hash := FileMultihash([]byte{}); hash != expected {
+		t.Errorf("expected %v, got %v", expected, hash)
+	}
+}
@@ -0,0 +1,123 @@
+package handshake
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nomasters/handshake/lib/config"
+	"github.com/nomasters/handshake/lib/storage"
+)
+
+// LoginLockedError is returned by NewSession when login attempts are locked out by the LockoutPolicy
+type LoginLockedError struct {
+	Until time.Time
+}
+
+func (e LoginLockedError) Error() string {
+	return fmt.Sprintf("too many failed login attempts: locked until %v", e.Until.Format(time.RFC3339))
+}
+
+// getLoginConfig takes a storage interface and a default config and returns the global config and an error.
+// A global config that fails its integrity check, or is missing, is replaced with the default config at
+// MaxLoginAttempts, so tampering with the counter locks logins rather than resetting them.
+func getLoginConfig(s storage.Storage, defaults config.Config) (config.Config, error) {
+	cfg, err := storage.GetGlobalConfig(s)
+	if err == storage.ErrGlobalConfigTampered {
+		cfg = defaults
+		cfg.MAC = nil
+		cfg.FailedLoginAttempts = cfg.MaxLoginAttempts
+		cfg.LastFailedLogin = time.Now().Unix()
+		return cfg, storage.ResetGlobalConfig(s, cfg)
+	}
+	return cfg, err
+}
+
+// checkLoginLockout takes a global config and returns a LoginLockedError if login attempts are locked out
+func checkLoginLockout(cfg config.Config) error {
+	if cfg.Policy() != config.LockoutPolicy {
+		return nil
+	}
+	delay := cfg.LockoutDelay()
+	if until := cfg.LastFailedLogin + delay; delay > 0 && time.Now().Unix() < until {
+		return LoginLockedError{Until: time.Unix(until, 0)}
+	}
+	return nil
+}
+
+// recordFailedLogin increments FailedLoginAttempts in the global config. With the WipePolicy, all profiles
+// and chats are wiped once MaxLoginAttempts is reached.
+func recordFailedLogin(s storage.Storage, cfg config.Config) error {
+	cfg.FailedLoginAttempts++
+	cfg.LastFailedLogin = time.Now().Unix()
+	if cfg.Policy() != config.WipePolicy || cfg.MaxLoginAttempts <= 0 || cfg.FailedLoginAttempts < cfg.MaxLoginAttempts {
+		return storage.SetGlobalConfig(s, cfg)
+	}
+	for _, prefix := range []string{profileKeyPrefix, rotationKeyPrefix, "chats/", handshakeKeyPrefix, blobKeyPrefix} {
+		if err := wipeAllWithPrefix(s, prefix); err != nil {
+			return err
+		}
+	}
+	cfg.FailedLoginAttempts = 0
+	cfg.LastFailedLogin = 0
+	return storage.SetGlobalConfig(s, cfg)
+}
+
+// resetFailedLogins resets FailedLoginAttempts in the global config after a successful login
+func resetFailedLogins(s storage.Storage, cfg config.Config) error {
+	if cfg.FailedLoginAttempts == 0 && cfg.LastFailedLogin == 0 {
+		return nil
+	}
+	cfg.FailedLoginAttempts = 0
+	cfg.LastFailedLogin = 0
+	return storage.SetGlobalConfig(s, cfg)
+}
+
+// SetLoginPolicy takes a LoginPolicy and the number of failed login attempts allowed before it is applied
+// and stores them in the global config. See LoginPolicy for the limits of protecting the counter.
+func (s *Session) SetLoginPolicy(policy config.LoginPolicy, maxAttempts int) error {
+	switch policy {
+	case config.LockoutPolicy, config.WipePolicy:
+	default:
+		return fmt.Errorf("unknown login policy: %v", policy)
+	}
+	if maxAttempts < 1 {
+		return errors.New("max login attempts must be greater than or equal to 1")
+	}
+	if err := s.active(); err != nil {
+		return err
+	}
+	cfg, err := storage.GetGlobalConfig(s.storage)
+	if err != nil {
+		return err
+	}
+	cfg.LoginPolicy = policy
+	cfg.MaxLoginAttempts = maxAttempts
+	return storage.SetGlobalConfig(s.storage, cfg)
+}
+
+// wipeAllWithPrefix overwrites the value of each key with a prefix with random data and then deletes it
+func wipeAllWithPrefix(s storage.Storage, prefix string) error {
+	keys, err := s.List(prefix)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := wipeKey(s, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// wipeKey overwrites the value of a key with random data and then deletes it
+func wipeKey(s storage.Storage, key string) error {
+	value, err := s.Get(key)
+	if err != nil {
+		return err
+	}
+	if _, err := s.Set(key, genRandBytes(len(value))); err != nil {
+		return err
+	}
+	return s.Delete(key)
+}
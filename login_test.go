@@ -0,0 +1,183 @@
+package handshake
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nomasters/handshake/lib/config"
+	"github.com/nomasters/handshake/lib/storage"
+)
+
+// newLoginTestStore creates a data store at path with the config and a profile for the password
+func newLoginTestStore(t *testing.T, path, password string, cfg config.Config) storage.Options {
+	opts := storage.Options{Engine: storage.BoltEngine, FilePath: path}
+	store, err := storage.NewStorage(cfg, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if err := initProfile(generateRandomProfile(), password, DefaultKDFParams, newTimeSeriesSBCipher(), store); err != nil {
+		t.Fatal(err)
+	}
+	return opts
+}
+
+// updateLoginTestConfig applies fn to the global config of the data store
+func updateLoginTestConfig(t *testing.T, opts storage.Options, fn func(store storage.Storage, cfg config.Config)) {
+	store, err := storage.NewStorage(config.NewConfig(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	cfg, err := storage.GetGlobalConfig(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn(store, cfg)
+}
+
+func TestLoginLockout(t *testing.T) {
+	path := "lockout-handshake.boltdb"
+	os.Remove(path)
+	defer os.Remove(path)
+	password := "lockout password"
+	cfg := config.NewConfig()
+	cfg.MaxLoginAttempts = 2
+	opts := newLoginTestStore(t, path, password, cfg)
+	sessionOpts := SessionOptions{StorageEngine: opts.Engine, StorageFilePath: path}
+
+	for i := 0; i < cfg.MaxLoginAttempts; i++ {
+		if _, err := NewSession("junk password", cfg, sessionOpts); err == nil {
+			t.Fatal("NewSession returned no error with bad password")
+		}
+	}
+	if _, err := NewSession(password, cfg, sessionOpts); err == nil {
+		t.Fatal("NewSession returned no error while locked out")
+	} else if _, ok := err.(LoginLockedError); !ok {
+		t.Fatalf("expected LoginLockedError, got %v", err)
+	}
+
+	updateLoginTestConfig(t, opts, func(store storage.Storage, c config.Config) {
+		c.LastFailedLogin = time.Now().Unix() - c.LockoutDelay()
+		if err := storage.SetGlobalConfig(store, c); err != nil {
+			t.Fatal(err)
+		}
+	})
+	s, err := NewSession(password, cfg, sessionOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	updateLoginTestConfig(t, opts, func(store storage.Storage, c config.Config) {
+		if c.FailedLoginAttempts != 0 {
+			t.Errorf("expected failed login attempts to be reset, got %v", c.FailedLoginAttempts)
+		}
+	})
+
+	// editing the counter without updating the mac locks logins
+	updateLoginTestConfig(t, opts, func(store storage.Storage, c config.Config) {
+		c.FailedLoginAttempts = 0
+		c.MaxLoginAttempts = 1000
+		if _, err := store.Set("global-config", c.ToJSON()); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if _, err := NewSession(password, cfg, sessionOpts); err == nil {
+		t.Fatal("NewSession returned no error with a tampered config")
+	} else if _, ok := err.(LoginLockedError); !ok {
+		t.Fatalf("expected LoginLockedError, got %v", err)
+	}
+
+	// deleting the config and its mac key locks logins rather than initializing a new config
+	updateLoginTestConfig(t, opts, func(store storage.Storage, c config.Config) {
+		c.FailedLoginAttempts = 0
+		if err := storage.SetGlobalConfig(store, c); err != nil {
+			t.Fatal(err)
+		}
+	})
+	store, err := storage.NewStorage(config.NewConfig(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"global-config", "global-config-mac-key"} {
+		if err := store.Delete(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.Close()
+	if _, err := NewSession(password, cfg, sessionOpts); err == nil {
+		t.Fatal("NewSession returned no error with a deleted config")
+	} else if _, ok := err.(LoginLockedError); !ok {
+		t.Fatalf("expected LoginLockedError, got %v", err)
+	}
+}
+
+func TestLoginWipe(t *testing.T) {
+	path := "wipe-handshake.boltdb"
+	os.Remove(path)
+	defer os.Remove(path)
+	password := "wipe password"
+	cfg := config.NewConfig()
+	cfg.LoginPolicy = config.WipePolicy
+	cfg.MaxLoginAttempts = 2
+	opts := newLoginTestStore(t, path, password, cfg)
+	sessionOpts := SessionOptions{StorageEngine: opts.Engine, StorageFilePath: path}
+
+	s, err := NewSession(password, cfg, sessionOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.setChatLog("a1b2c3", ChatLog{}); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	for i := 0; i < cfg.MaxLoginAttempts; i++ {
+		if _, err := NewSession("junk password", cfg, sessionOpts); err == nil {
+			t.Fatal("NewSession returned no error with bad password")
+		}
+	}
+	updateLoginTestConfig(t, opts, func(store storage.Storage, c config.Config) {
+		for _, prefix := range []string{profileKeyPrefix, "chats/"} {
+			if keys, _ := store.List(prefix); len(keys) != 0 {
+				t.Errorf("expected %v to be wiped, found %v", prefix, keys)
+			}
+		}
+	})
+}
+
+func TestLoginBaselineStore(t *testing.T) {
+	path := "baseline-login-handshake.boltdb"
+	os.Remove(path)
+	defer os.Remove(path)
+	// testdata/baseline-handshake.boltdb was written by NewGenesisProfile before login policies and profile
+	// KDF versions, with an unsigned global config and no mac key
+	b, err := ioutil.ReadFile("testdata/baseline-handshake.boltdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.NewConfig()
+	opts := SessionOptions{StorageEngine: storage.BoltEngine, StorageFilePath: path}
+
+	if _, err := NewSession("junk password", cfg, opts); err == nil {
+		t.Fatal("NewSession returned no error with bad password")
+	}
+	s, err := NewSession("baseline password", cfg, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.locker.KDF != DefaultKDFParams {
+		t.Errorf("expected the profile to be upgraded to %+v, got %+v", DefaultKDFParams, s.locker.KDF)
+	}
+	s.Close()
+	updateLoginTestConfig(t, storage.Options{Engine: storage.BoltEngine, FilePath: path}, func(store storage.Storage, c config.Config) {
+		if len(c.MAC) == 0 || c.FailedLoginAttempts != 0 {
+			t.Errorf("expected a signed config with failed logins reset, got %+v", c)
+		}
+	})
+}
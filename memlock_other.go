@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package handshake
+
+// lockMemory is a no-op on platforms without mlock support
+func lockMemory(b []byte) {}
+
+// unlockMemory is a no-op on platforms without mlock support
+func unlockMemory(b []byte) {}
@@ -0,0 +1,23 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package handshake
+
+import (
+	"syscall"
+)
+
+// lockMemory locks the pages holding b in memory so they are not swapped to disk. Locking is best effort,
+// since it can fail when the process exceeds its locked memory limit.
+func lockMemory(b []byte) {
+	if len(b) > 0 {
+		syscall.Mlock(b)
+	}
+}
+
+// unlockMemory unlocks the pages holding b
+func unlockMemory(b []byte) {
+	if len(b) > 0 {
+		syscall.Munlock(b)
+	}
+}
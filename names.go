@@ -0,0 +1,268 @@
+package handshake
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/nomasters/handshake/lib/storage"
+	"golang.org/x/crypto/blake2b"
+)
+
+const (
+	// blobKeyPrefix is the prefix used for storage keys with obfuscated names
+	blobKeyPrefix = "blobs/"
+	// keyIndexPath is the logical path of the encrypted index of keys stored with obfuscated names
+	keyIndexPath = "index"
+	// keyIndexKeyPath is hashed to derive the key the index is encrypted with. Logical paths never start with
+	// a NUL byte, so it can't collide with the name of a stored key.
+	keyIndexKeyPath = "\x00index-key"
+)
+
+// keyNamer is the names key of a profile. It maps logical storage paths, such as chats/<chat>/<profile>/config,
+// to storage keys named with a keyed blake2b-256 hash of the path, so that someone holding the data store
+// learns nothing from them beyond the number of blobs stored.
+type keyNamer []byte
+
+// sum takes a logical path and returns its keyed blake2b-256 hash
+func (n keyNamer) sum(path string) []byte {
+	h, err := blake2b.New256(n)
+	if err != nil {
+		// names keys are generated with profileKeyLength, well below the blake2b max key length
+		panic(err)
+	}
+	h.Write([]byte(path))
+	return h.Sum(nil)
+}
+
+// name takes a logical path and returns the obfuscated storage key it is stored under
+func (n keyNamer) name(path string) string {
+	return blobKeyPrefix + hex.EncodeToString(n.sum(path))
+}
+
+// keyIndex holds the logical paths of the keys a profile stores with obfuscated names. It is stored encrypted
+// under the obfuscated name of keyIndexPath and stands in for storage List.
+type keyIndex map[string]bool
+
+// list takes a prefix and returns the sorted logical paths in the index that start with it
+func (i keyIndex) list(prefix string) []string {
+	var paths []string
+	for path := range i {
+		if strings.HasPrefix(path, prefix) {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// getKeyIndex takes a storage interface, cipher, and keyNamer and returns the keyIndex and an error. An empty
+// keyIndex is returned if none has been stored.
+func getKeyIndex(s storage.Storage, cipher cipher, n keyNamer) (keyIndex, error) {
+	index := make(keyIndex)
+	encrypted, err := s.Get(n.name(keyIndexPath))
+	if err != nil {
+		return index, err
+	}
+	if len(encrypted) == 0 {
+		return index, nil
+	}
+	b, err := cipher.Decrypt(encrypted, n.sum(keyIndexKeyPath))
+	if err != nil {
+		return index, err
+	}
+	var buffer bytes.Buffer
+	buffer.Write(b)
+	err = gob.NewDecoder(&buffer).Decode(&index)
+	return index, err
+}
+
+// setKeyIndex takes a storage interface, cipher, keyNamer, and keyIndex and stores the encrypted keyIndex
+func setKeyIndex(s storage.Storage, cipher cipher, n keyNamer, index keyIndex) error {
+	b, err := encodeGob(index)
+	if err != nil {
+		return err
+	}
+	encrypted, err := cipher.Encrypt(b, n.sum(keyIndexKeyPath))
+	if err != nil {
+		return err
+	}
+	_, err = s.Set(n.name(keyIndexPath), encrypted)
+	return err
+}
+
+// wipeObfuscatedKeys takes a storage interface, cipher, and keyNamer and wipes every key in the keyIndex
+// along with the keyIndex itself. It does nothing for an empty keyNamer.
+func wipeObfuscatedKeys(s storage.Storage, cipher cipher, n keyNamer) error {
+	if len(n) == 0 {
+		return nil
+	}
+	index, err := getKeyIndex(s, cipher, n)
+	if err != nil {
+		return err
+	}
+	for path := range index {
+		if err := wipeKey(s, n.name(path)); err != nil {
+			return err
+		}
+	}
+	return wipeKey(s, n.name(keyIndexPath))
+}
+
+// obfuscated returns true if the session profile stores its data with obfuscated key names
+func (s *Session) obfuscated() bool {
+	return s.profile.Settings.ObfuscateKeys && len(s.profile.NamesKey) > 0
+}
+
+// storageKey takes a logical path and returns the storage key it is stored under
+func (s *Session) storageKey(path string) string {
+	if !s.obfuscated() {
+		return path
+	}
+	return keyNamer(s.profile.NamesKey).name(path)
+}
+
+// keyIndex returns the keyIndex of the session profile and an error. It is loaded once and then cached.
+func (s *Session) keyIndex() (keyIndex, error) {
+	if s.index != nil {
+		return s.index, nil
+	}
+	index, err := getKeyIndex(s.storage, s.cipher, s.profile.NamesKey)
+	if err != nil {
+		return nil, err
+	}
+	s.index = index
+	return index, nil
+}
+
+// indexPath takes a logical path and adds it to the keyIndex if key names are obfuscated
+func (s *Session) indexPath(path string) error {
+	if !s.obfuscated() {
+		return nil
+	}
+	index, err := s.keyIndex()
+	if err != nil {
+		return err
+	}
+	if index[path] {
+		return nil
+	}
+	index[path] = true
+	if err := setKeyIndex(s.storage, s.cipher, s.profile.NamesKey, index); err != nil {
+		delete(index, path)
+		return err
+	}
+	return nil
+}
+
+// list takes a prefix and returns the logical paths stored with it and an error. With obfuscated key names,
+// the paths are read from the keyIndex of the session profile.
+func (s *Session) list(prefix string) ([]string, error) {
+	if !s.obfuscated() {
+		return s.storage.List(prefix)
+	}
+	index, err := s.keyIndex()
+	if err != nil {
+		return []string{}, err
+	}
+	return index.list(prefix), nil
+}
+
+// delete takes a logical path and deletes it from storage and the keyIndex
+func (s *Session) delete(path string) error {
+	if err := s.storage.Delete(s.storageKey(path)); err != nil {
+		return err
+	}
+	if !s.obfuscated() {
+		return nil
+	}
+	index, err := s.keyIndex()
+	if err != nil {
+		return err
+	}
+	delete(index, path)
+	return setKeyIndex(s.storage, s.cipher, s.profile.NamesKey, index)
+}
+
+// wipe takes a logical path, overwrites its value with random data, and deletes it from storage and the keyIndex
+func (s *Session) wipe(path string) error {
+	if err := wipeKey(s.storage, s.storageKey(path)); err != nil {
+		return err
+	}
+	return s.delete(path)
+}
+
+// deleteAllWithPrefix takes a prefix and deletes all logical paths stored with it
+func (s *Session) deleteAllWithPrefix(prefix string) error {
+	paths, err := s.list(prefix)
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if err := s.delete(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EnableKeyObfuscation moves the chat data of the session profile from cleartext keys such as
+// chats/<chat>/<profile>/config to keys named with a keyed hash of the path, and stores data that way from then
+// on. The cleartext keys are wiped once the profile is updated. If an earlier call was interrupted, calling it
+// again completes it.
+func (s *Session) EnableKeyObfuscation() error {
+	if err := s.active(); err != nil {
+		return err
+	}
+	if pending, err := s.profileKeyRotationPending(); err != nil || pending {
+		return errors.New("a profile key rotation must be completed or rolled back before enabling key obfuscation")
+	}
+	p := s.profile
+	if len(p.NamesKey) == 0 {
+		// the decoy holds the names key to wipe this profile, so it can't be generated here
+		if p.Settings.DuressProfileID != "" {
+			return errors.New("the duress profile must be set up again before enabling key obfuscation")
+		}
+		p.NamesKey = genRandBytes(profileKeyLength)
+	}
+	n := keyNamer(p.NamesKey)
+	paths, err := profileCleartextKeys(s.storage, p.ID)
+	if err != nil {
+		return err
+	}
+	index, err := getKeyIndex(s.storage, s.cipher, n)
+	if err != nil {
+		return err
+	}
+	if !p.Settings.ObfuscateKeys {
+		for _, path := range paths {
+			index[path] = true
+		}
+		if err := setKeyIndex(s.storage, s.cipher, n, index); err != nil {
+			return err
+		}
+		for _, path := range paths {
+			value, err := s.storage.Get(path)
+			if err != nil {
+				return err
+			}
+			if _, err := s.storage.Set(n.name(path), value); err != nil {
+				return err
+			}
+		}
+		p.Settings.ObfuscateKeys = true
+		if err := s.locker.lock(p, s.cipher, s.storage); err != nil {
+			return err
+		}
+		s.setProfile(p)
+	}
+	for _, path := range paths {
+		if err := wipeKey(s.storage, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
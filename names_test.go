@@ -0,0 +1,137 @@
+package handshake
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/nomasters/handshake/lib/config"
+	"github.com/nomasters/handshake/lib/storage"
+)
+
+func TestKeyObfuscation(t *testing.T) {
+	path := "names-handshake.boltdb"
+	os.Remove(path)
+	defer os.Remove(path)
+	password := "names password"
+	chatID := "a1b2c3"
+	cfg := config.NewConfig()
+	opts := SessionOptions{StorageEngine: storage.BoltEngine, StorageFilePath: path}
+
+	s := newRotationTestSession(t, path, password, chatID)
+	profileID := s.GetProfile().ID
+	if err := s.EnableKeyObfuscation(); err != nil {
+		t.Fatal(err)
+	}
+	checkRotationTestData(t, s, chatID)
+	if err := s.setChatLog("d4e5f6", ChatLog{}); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := s.storage.List("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var blobs int
+	for _, key := range keys {
+		if strings.Contains(key, profileID) && key != profileKeyPrefix+profileID {
+			t.Errorf("cleartext key remains: %v", key)
+		}
+		if strings.HasPrefix(key, blobKeyPrefix) {
+			blobs++
+		}
+	}
+	// three chat keys, a chat log, and the index
+	if blobs != 5 {
+		t.Errorf("expected 5 blobs, got %v", blobs)
+	}
+	if err := s.RotateProfileKey(); err != nil {
+		t.Fatal(err)
+	}
+	checkRotationTestData(t, s, chatID)
+	s.Close()
+
+	s, err = NewSession(password, cfg, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRotationTestData(t, s, chatID)
+	listBytes, err := s.ListChats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list []string
+	if err := json.Unmarshal(listBytes, &list); err != nil || len(list) != 2 {
+		t.Errorf("expected 2 chats, got %v: %v", string(listBytes), err)
+	}
+	if err := s.SetupDuressProfile(password, "duress password", true); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	decoy, err := NewSession("duress password", cfg, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer decoy.Close()
+	decoy.wipes.Wait()
+	if keys, err := decoy.storage.List(blobKeyPrefix); err != nil || len(keys) != 0 {
+		t.Errorf("expected obfuscated keys to be wiped, found %v: %v", keys, err)
+	}
+}
+
+func TestKeyObfuscationAfterDuress(t *testing.T) {
+	path := "names-duress-handshake.boltdb"
+	os.Remove(path)
+	defer os.Remove(path)
+	password := "names password"
+	duressPassword := "duress password"
+	chatID := "a1b2c3"
+	cfg := config.NewConfig()
+	opts := SessionOptions{StorageEngine: storage.BoltEngine, StorageFilePath: path}
+
+	// a profile created without a names key
+	s := newRotationTestSession(t, path, password, chatID)
+	p := s.GetProfile()
+	p.NamesKey = nil
+	if err := s.locker.lock(p, s.cipher, s.storage); err != nil {
+		t.Fatal(err)
+	}
+	s.setProfile(p)
+	if err := s.SetupDuressProfile(password, duressPassword, true); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s, err := NewSession(password, cfg, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.GetProfile().NamesKey) == 0 {
+		t.Error("expected SetupDuressProfile to generate a names key")
+	}
+	if err := s.EnableKeyObfuscation(); err != nil {
+		t.Fatal(err)
+	}
+	checkRotationTestData(t, s, chatID)
+	s.Close()
+
+	decoy, err := NewSession(duressPassword, cfg, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer decoy.Close()
+	decoy.wipes.Wait()
+	if keys, err := decoy.storage.List(blobKeyPrefix); err != nil || len(keys) != 0 {
+		t.Errorf("expected obfuscated keys to be wiped, found %v: %v", keys, err)
+	}
+
+	// a names key can't be generated once a decoy holds the previous one
+	p = decoy.GetProfile()
+	p.NamesKey = nil
+	p.Settings.DuressProfileID = "a1b2c3"
+	decoy.setProfile(p)
+	if err := decoy.EnableKeyObfuscation(); err == nil {
+		t.Error("expected an error generating a names key with a duress profile set up")
+	}
+}
@@ -0,0 +1,88 @@
+package handshake
+
+import (
+	"errors"
+)
+
+// PaddingScheme is used for type enumeration of the padding applied to plaintext before encryption
+type PaddingScheme int
+
+const (
+	// NoPadding sends plaintext as is, which reveals the length of a message through its ciphertext
+	NoPadding PaddingScheme = iota
+	// PowerOfTwoPadding pads plaintext to the next power of two bucket
+	PowerOfTwoPadding
+	// BlockPadding pads plaintext to the next multiple of a fixed block size
+	BlockPadding
+)
+
+const (
+	// DefaultPaddingScheme is the PaddingScheme used for new chats
+	DefaultPaddingScheme = PowerOfTwoPadding
+	// paddingMarker is the byte appended to plaintext to mark the start of padding. It is followed only by
+	// zero bytes, so padding can be stripped without knowing the scheme used to apply it.
+	paddingMarker = 0x80
+	// paddingMinLength is the smallest bucket, in bytes, for PowerOfTwoPadding
+	paddingMinLength = 256
+	// paddingBlockSize is the size of a block, in bytes, for BlockPadding
+	paddingBlockSize = 4096
+	// paddingMaxLength is the largest padded length, in bytes. It keeps padded messages within a single
+	// IPFS block. Plaintext at or above this length only has the padding marker appended.
+	paddingMaxLength = 256000
+	// rendezvousPaddedLength is the length, in bytes, that every rendezvous hash is padded to regardless of the
+	// chat PaddingScheme. With lookup keys and cipher overhead, it stays within hashmap.MaxMessageBytes.
+	rendezvousPaddedLength = 128
+)
+
+// pad takes a PaddingScheme and plaintext and returns the padded plaintext and an error. Padded plaintext
+// is the original plaintext followed by the paddingMarker and zero or more zero bytes. NoPadding returns
+// the plaintext unmodified.
+func pad(scheme PaddingScheme, data []byte) ([]byte, error) {
+	var length int
+	switch scheme {
+	case NoPadding:
+		return data, nil
+	case PowerOfTwoPadding:
+		length = paddingMinLength
+		for length < len(data)+1 {
+			length *= 2
+		}
+	case BlockPadding:
+		length = ((len(data) / paddingBlockSize) + 1) * paddingBlockSize
+	default:
+		return nil, errors.New("padding scheme is not implemented")
+	}
+	if length > paddingMaxLength {
+		length = paddingMaxLength
+	}
+	return padTo(length, data), nil
+}
+
+// padTo takes a length and plaintext and returns the plaintext followed by the paddingMarker and zero bytes up
+// to the length. Plaintext at or above the length only has the paddingMarker appended.
+func padTo(length int, data []byte) []byte {
+	if length < len(data)+1 {
+		length = len(data) + 1
+	}
+	padded := make([]byte, length)
+	copy(padded, data)
+	padded[len(data)] = paddingMarker
+	return padded
+}
+
+// unpad takes plaintext and strips padding if it is present. Plaintext that was not padded is returned
+// unmodified, which keeps messages from peers that don't pad readable. This relies on unpadded payloads,
+// JSON encoded chatData and storage hashes, never ending in the paddingMarker followed by zero bytes.
+func unpad(data []byte) []byte {
+	for i := len(data) - 1; i >= 0; i-- {
+		switch data[i] {
+		case 0:
+			continue
+		case paddingMarker:
+			return data[:i]
+		default:
+			return data
+		}
+	}
+	return data
+}
@@ -0,0 +1,156 @@
+package handshake
+
+import (
+	"bytes"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/nomasters/hashmap"
+
+	"github.com/nomasters/handshake/lib/hashmapserver"
+	"github.com/nomasters/handshake/lib/ipfsserver"
+)
+
+func TestPadding(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		scheme PaddingScheme
+		data   []byte
+		length int
+	}{
+		{NoPadding, []byte("hello, world"), 12},
+		{PowerOfTwoPadding, []byte{}, 256},
+		{PowerOfTwoPadding, []byte("hello, world"), 256},
+		{PowerOfTwoPadding, make([]byte, 255), 256},
+		{PowerOfTwoPadding, make([]byte, 256), 512},
+		{PowerOfTwoPadding, make([]byte, 200000), paddingMaxLength},
+		{PowerOfTwoPadding, make([]byte, paddingMaxLength), paddingMaxLength + 1},
+		{BlockPadding, []byte("hello, world"), 4096},
+		{BlockPadding, make([]byte, 4096), 8192},
+	}
+
+	for _, tc := range testCases {
+		padded, err := pad(tc.scheme, tc.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(padded) != tc.length {
+			t.Errorf("padded length for scheme %v and %v bytes: expected %v, got %v", tc.scheme, len(tc.data), tc.length, len(padded))
+		}
+		if !bytes.Equal(unpad(padded), tc.data) {
+			t.Errorf("unpad for scheme %v and %v bytes did not return original data", tc.scheme, len(tc.data))
+		}
+	}
+
+	if _, err := pad(PaddingScheme(100), []byte("hello")); err == nil {
+		t.Error("pad returned no error for unknown padding scheme")
+	}
+
+	// messages from peers that don't pad are returned as is
+	unpadded := []byte(`{"message":"hello"}`)
+	if !bytes.Equal(unpad(unpadded), unpadded) {
+		t.Error("unpad modified unpadded data")
+	}
+
+	// padded messages of different lengths in the same bucket encrypt to the same length
+	c := newDefaultCipher()
+	key := make([]byte, 32)
+	var lengths []int
+	for _, m := range []string{"hi", "a much longer message, but still in the same bucket"} {
+		padded, _ := pad(DefaultPaddingScheme, []byte(m))
+		cipherText, err := c.Encrypt(padded, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lengths = append(lengths, len(cipherText))
+	}
+	if lengths[0] != lengths[1] {
+		t.Errorf("ciphertext lengths differ: %v", lengths)
+	}
+}
+
+func TestRendezvousPadding(t *testing.T) {
+	hash := "QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o"
+	for ct, e := range cipherEngines {
+		c := e.New()
+		cipherText, err := c.Encrypt(padTo(rendezvousPaddedLength, []byte(hash)), make([]byte, e.KeyLength))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if length := lookupHashLength + len(cipherText); length > hashmap.MaxMessageBytes {
+			t.Errorf("%v: rendezvous payload of %v bytes exceeds %v", ct, length, hashmap.MaxMessageBytes)
+		}
+	}
+}
+
+func TestSendReceivePadding(t *testing.T) {
+	rendezvous := httptest.NewServer(hashmapserver.New())
+	defer rendezvous.Close()
+	messages := httptest.NewServer(ipfsserver.New())
+	defer messages.Close()
+
+	for _, scheme := range []PaddingScheme{NoPadding, PowerOfTwoPadding, BlockPadding} {
+		t.Run(fmt.Sprintf("scheme %v", scheme), func(t *testing.T) {
+			initiatorPath := fmt.Sprintf("padding-%v-initiator-handshake.boltdb", scheme)
+			peerPath := fmt.Sprintf("padding-%v-peer-handshake.boltdb", scheme)
+			for _, path := range []string{initiatorPath, peerPath} {
+				os.Remove(path)
+				defer os.Remove(path)
+			}
+			a := newGroupTestSession(t, initiatorPath, initiator, rendezvous.URL, messages.URL)
+			defer a.Close()
+			b := newGroupTestSession(t, peerPath, peer, rendezvous.URL, messages.URL)
+			defer b.Close()
+			if err := a.SetHandshakePadding(scheme); err != nil {
+				t.Fatal(err)
+			}
+			share, err := b.ShareHandshakePosition()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := a.AddPeerToHandshake(share); err != nil {
+				t.Fatal(err)
+			}
+			configs, err := a.GetAllHandshakePeerConfigs()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := b.AddPeersToHandshake(configs); err != nil {
+				t.Fatal(err)
+			}
+			chatID, err := a.NewChat()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := b.NewChat(); err != nil {
+				t.Fatal(err)
+			}
+			for _, pair := range [][2]*Session{{a, b}, {b, a}} {
+				if _, err := pair[0].SendMessage(chatID, []byte(`{ "message": "padded hello" }`)); err != nil {
+					t.Fatal(err)
+				}
+				log, err := pair[1].RetrieveMessages(chatID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Contains(log, []byte("padded hello")) {
+					t.Error("message was not received")
+				}
+			}
+			// the largest message fits a single IPFS block with any padding scheme
+			large := fmt.Sprintf(`{ "message": "%v" }`, strings.Repeat("a", maxMessageSize-200))
+			if _, err := a.SendMessage(chatID, []byte(large)); err != nil {
+				t.Fatalf("largest message was not sent: %v", err)
+			}
+			// a message that grows past maxMessageSize when encoded is rejected
+			escaped := fmt.Sprintf(`{ "message": "%v" }`, strings.Repeat("<", maxMessageSize/5))
+			if _, err := a.SendMessage(chatID, []byte(escaped)); err != ErrMessageTooLarge {
+				t.Errorf("expected ErrMessageTooLarge, got %v", err)
+			}
+		})
+	}
+}
@@ -5,7 +5,9 @@ import (
 	"encoding/base64"
 	"encoding/gob"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"strings"
 
 	"github.com/nomasters/handshake/lib/config"
@@ -20,28 +22,60 @@ const (
 	profileKeyLength = 32
 	// profileKeyPrefix is the prefix used for the profile keys
 	profileKeyPrefix = "profiles/"
+	// profilePaddingSize is the block size in bytes that encoded profiles are padded to before encryption
+	profilePaddingSize = 512
+	// maxProfileLabelLength is the maximum length in bytes of a profile display label
+	maxProfileLabelLength = 64
 )
 
 // Profile represents a profile that has been accessed
 // this would contain successfully decrypted profile data
 type Profile struct {
-	ID       string
-	Key      []byte
+	ID  string
+	Key []byte
+	// NamesKey is the key used to hash storage key names when Settings.ObfuscateKeys is set
+	NamesKey []byte
 	Settings profileSettings
 }
 
 // ProfileSettings holds profile settings info
 type profileSettings struct {
 	SessionTTL int64
+	// Label is the display label of the profile. It is only readable once the profile is decrypted.
+	Label string
+	// DuressProfileID is the ID of the decoy profile opened by the duress password
+	DuressProfileID string
+	// ObfuscateKeys stores chat data under keys named with a keyed hash of their path
+	ObfuscateKeys bool
+	// NamesKeys holds the names key of the DuressProfileID, so that its data can be found and deleted if it is
+	// stored under obfuscated keys
+	NamesKeys map[string][]byte
+}
+
+// profileWipe identifies a profile whose chat data is wiped when the profile holding it logs in
+type profileWipe struct {
+	ProfileID string
+	NamesKey  []byte
+}
+
+// profileRecord is the encrypted form of a Profile. It has the fields of a Profile, so profiles stored before
+// it decode into it, along with the profileWipes of the profile. The wipes are kept in the profileLocker rather
+// than the Profile, so a decoy profile doesn't reveal the profile it wipes through its settings.
+type profileRecord struct {
+	ID       string
+	Key      []byte
+	NamesKey []byte
+	Settings profileSettings
+	Wipes    []profileWipe
 }
 
-// takes gob encoded byte slice and returns a lookup and error
-func newProfileFromGob(b []byte) (Profile, error) {
-	var p Profile
+// takes gob encoded byte slice and returns a profileRecord and error
+func newProfileRecordFromGob(b []byte) (profileRecord, error) {
+	var r profileRecord
 	var buffer bytes.Buffer
 	buffer.Write(b)
-	err := gob.NewDecoder(&buffer).Decode(&p)
-	return p, err
+	err := gob.NewDecoder(&buffer).Decode(&r)
+	return r, err
 }
 
 // ProfilesExist configures a storage engine and checks `profilesExist`. It returns a bool and error.
@@ -74,6 +108,18 @@ func profilesExist(storage storage.Storage) (bool, error) {
 
 // NewGenesisProfile takes password and
 func NewGenesisProfile(password string) error {
+	return NewGenesisProfileWithKDF(password, DefaultKDFParams)
+}
+
+// NewGenesisProfileWithKDF takes a password and KDFParams and sets up the initial profile with a key derived
+// using the KDFParams. The KDFParams are stored alongside the encrypted profile.
+func NewGenesisProfileWithKDF(password string, params KDFParams) error {
+	if params.Version == LegacyKDF {
+		return errors.New("legacy kdf params may not be used for new profiles")
+	}
+	if err := params.Validate(); err != nil {
+		return err
+	}
 	cfg := config.NewConfig()
 	opts := storage.Options{Engine: storage.DefaultStorageEngine}
 	storage, err := storage.NewStorage(cfg, opts)
@@ -89,39 +135,179 @@ func NewGenesisProfile(password string) error {
 		return errors.New("existing profiles found: this function may only be used for initial setup")
 	}
 
-	return initProfile(generateRandomProfile(), password, newTimeSeriesSBCipher(), storage)
+	return initProfile(generateRandomProfile(), password, params, newTimeSeriesSBCipher(), storage)
 }
 
-func initProfile(p Profile, password string, cipher cipher, storage storage.Storage) error {
-	id, err := p.IDBytes()
+// NewDefaultProfile is a wrapper around NewProfile and applies simple defaults
+func NewDefaultProfile(password, label string) error {
+	cfg := config.NewConfig()
+	opts := SessionOptions{StorageEngine: storage.DefaultStorageEngine}
+	return NewProfile(password, label, cfg, opts)
+}
+
+// NewProfile takes a password, display label, and opts and adds a profile to a data store. Unlike
+// NewGenesisProfile, it may be used when profiles already exist, so that a store can hold a profile per
+// user. The password must not open an existing profile.
+func NewProfile(password, label string, cfg config.Config, opts SessionOptions) error {
+	if len(label) > maxProfileLabelLength {
+		return fmt.Errorf("profile label exceeds max length of %v bytes", maxProfileLabelLength)
+	}
+	storageOpts := storage.Options{Engine: opts.StorageEngine, FilePath: opts.StorageFilePath, SecureDelete: opts.SecureDelete}
+	storage, err := storage.NewStorage(cfg, storageOpts)
 	if err != nil {
-		return errors.New("profile id failed to decode hex")
+		return err
+	}
+	defer storage.Close()
+	cipher := newTimeSeriesSBCipher()
+	opens, err := passwordOpensProfile(storage, cipher, password, "")
+	if err != nil {
+		return err
+	}
+	if opens {
+		return errors.New("password opens an existing profile")
+	}
+	p := generateRandomProfile()
+	p.Settings.Label = label
+	return initProfile(p, password, DefaultKDFParams, cipher, storage)
+}
+
+// ListProfiles takes opts and returns a json encoded list of the profile IDs in a data store and an error.
+// Profiles are listed in storage order and carry no readable details, so the list does not reveal which
+// profile belongs to whom, or which are decoys.
+func ListProfiles(cfg config.Config, opts SessionOptions) ([]byte, error) {
+	storageOpts := storage.Options{Engine: opts.StorageEngine, FilePath: opts.StorageFilePath, SecureDelete: opts.SecureDelete}
+	storage, err := storage.NewStorage(cfg, storageOpts)
+	if err != nil {
+		return []byte{}, err
+	}
+	defer storage.Close()
+	paths, err := storage.List(profileKeyPrefix)
+	if err != nil {
+		return []byte{}, err
+	}
+	ids := []string{}
+	for _, path := range paths {
+		ids = append(ids, strings.TrimPrefix(path, profileKeyPrefix))
+	}
+	return json.Marshal(ids)
+}
+
+// passwordOpensProfile takes a storage interface, cipher, password, and a profile ID to skip and returns true
+// if the password decrypts any other profile and an error
+func passwordOpensProfile(s storage.Storage, cipher cipher, password, skipID string) (bool, error) {
+	profilePaths, err := s.List(profileKeyPrefix)
+	if err != nil {
+		return false, err
+	}
+	for _, profilePath := range profilePaths {
+		if skipID != "" && profilePath == profileKeyPrefix+skipID {
+			continue
+		}
+		id, err := getIDFromPath(profilePath)
+		if err != nil {
+			return false, err
+		}
+		if _, err := verifyProfilePassword(profilePath, id, password, cipher, s); err == nil {
+			return true, nil
+		}
 	}
-	key := deriveKey([]byte(password), id)
-	encodedProfile, err := encodeGob(p)
+	return false, nil
+}
+
+// profileLocker holds the key derived from a password that wraps a Profile, the KDFParams used to derive it, and
+// the profileWipes stored with the Profile
+type profileLocker struct {
+	Key   []byte
+	KDF   KDFParams
+	Wipes []profileWipe
+}
+
+// newProfileLocker takes a password, profile ID, and KDFParams and returns a profileLocker and an error
+func newProfileLocker(password string, id []byte, params KDFParams) (profileLocker, error) {
+	key, err := deriveKey([]byte(password), id, params)
+	return profileLocker{Key: key, KDF: params}, err
+}
+
+// lock encrypts the Profile with the locker key and stores it with the locker KDFParams under profiles/<id>.
+// The encoded profile is zero padded to a multiple of profilePaddingSize, so that profiles with different
+// settings can't be told apart by size. The gob decoder ignores the trailing padding.
+func (l profileLocker) lock(p Profile, cipher cipher, storage storage.Storage) error {
+	encodedProfile, err := encodeGob(profileRecord{
+		ID:       p.ID,
+		Key:      p.Key,
+		NamesKey: p.NamesKey,
+		Settings: p.Settings,
+		Wipes:    l.Wipes,
+	})
+	defer zeroBytes(encodedProfile)
 	if err != nil {
 		return err
 	}
-	data, err := cipher.Encrypt(encodedProfile, key)
+	padding := profilePaddingSize - len(encodedProfile)%profilePaddingSize
+	paddedProfile := make([]byte, len(encodedProfile)+padding)
+	copy(paddedProfile, encodedProfile)
+	defer zeroBytes(paddedProfile)
+	data, err := cipher.Encrypt(paddedProfile, l.Key)
 	if err != nil {
 		return err
 	}
-	_, err = storage.Set(profileKeyPrefix+p.ID, data)
+	_, err = storage.Set(profileKeyPrefix+p.ID, encodeProfileBlob(l.KDF, data))
 	return err
 }
 
-// GetProfileFromEncryptedStorage takes a storage path, password, and storage interface and returns a Profile struct and error.DecryptProfile
-//
-func getProfileFromEncryptedStorage(path string, key []byte, cipher cipher, storage storage.Storage) (Profile, error) {
+func initProfile(p Profile, password string, params KDFParams, cipher cipher, storage storage.Storage) error {
+	_, err := initProfileLocker(p, nil, password, params, cipher, storage)
+	return err
+}
+
+// initProfileLocker takes a Profile, its profileWipes, a password, and KDFParams and stores the Profile wrapped
+// with a new profileLocker. It returns the profileLocker and an error.
+func initProfileLocker(p Profile, wipes []profileWipe, password string, params KDFParams, cipher cipher, storage storage.Storage) (profileLocker, error) {
+	id, err := p.IDBytes()
+	if err != nil {
+		return profileLocker{}, errors.New("profile id failed to decode hex")
+	}
+	locker, err := newProfileLocker(password, id, params)
+	if err != nil {
+		return profileLocker{}, err
+	}
+	locker.Wipes = wipes
+	return locker, locker.lock(p, cipher, storage)
+}
+
+// GetProfileFromEncryptedStorage takes a storage path, profile ID, password, and storage interface and returns a
+// Profile struct, the profileLocker that wraps it, and an error.
+func getProfileFromEncryptedStorage(path string, id []byte, password string, cipher cipher, storage storage.Storage) (Profile, profileLocker, error) {
 	data, err := storage.Get(path)
 	if err != nil {
-		return Profile{}, err
+		return Profile{}, profileLocker{}, err
 	}
-	pBytes, err := cipher.Decrypt(data, key)
+	params, encrypted, err := decodeProfileBlob(data)
 	if err != nil {
-		return Profile{}, err
+		return Profile{}, profileLocker{}, err
 	}
-	return newProfileFromGob(pBytes)
+	locker, err := newProfileLocker(password, id, params)
+	if err != nil {
+		return Profile{}, locker, err
+	}
+	pBytes, err := cipher.Decrypt(encrypted, locker.Key)
+	if err != nil {
+		zeroBytes(locker.Key)
+		return Profile{}, profileLocker{}, err
+	}
+	defer zeroBytes(pBytes)
+	r, err := newProfileRecordFromGob(pBytes)
+	locker.Wipes = r.Wipes
+	return Profile{ID: r.ID, Key: r.Key, NamesKey: r.NamesKey, Settings: r.Settings}, locker, err
+}
+
+// verifyProfilePassword takes the same arguments as getProfileFromEncryptedStorage and returns the ID of the
+// profile if the password decrypts it and an error. Key material decrypted during the check is zeroed.
+func verifyProfilePassword(path string, id []byte, password string, cipher cipher, storage storage.Storage) (string, error) {
+	p, locker, err := getProfileFromEncryptedStorage(path, id, password, cipher, storage)
+	zeroBytes(p.Key)
+	zeroBytes(locker.Key)
+	return p.ID, err
 }
 
 func getIDFromPath(path string) ([]byte, error) {
@@ -132,8 +318,9 @@ func getIDFromPath(path string) ([]byte, error) {
 // GenerateRandomProfile returns a Profile struct with a randomly generated ID and key
 func generateRandomProfile() Profile {
 	return Profile{
-		ID:  hex.EncodeToString(genRandBytes(profileIDLength)),
-		Key: genRandBytes(profileKeyLength),
+		ID:       hex.EncodeToString(genRandBytes(profileIDLength)),
+		Key:      genRandBytes(profileKeyLength),
+		NamesKey: genRandBytes(profileKeyLength),
 	}
 }
 
@@ -151,3 +338,205 @@ func (p Profile) KeyHex() string {
 func (p Profile) KeyBase64() string {
 	return base64.StdEncoding.EncodeToString(p.Key)
 }
+
+// ChangePassword takes the current and new password and re-encrypts the profile under a key derived from the
+// new password. The profile is replaced with a single storage Set, which the bolt engine commits in one
+// transaction, so an interruption leaves either the current or the new password valid.
+func (s *Session) ChangePassword(oldPassword, newPassword string) error {
+	if err := s.active(); err != nil {
+		return err
+	}
+	if newPassword == "" {
+		return errors.New("new password must not be empty")
+	}
+	path := profileKeyPrefix + s.profile.ID
+	id, err := s.profile.IDBytes()
+	if err != nil {
+		return errors.New("profile id failed to decode hex")
+	}
+	previous, err := s.storage.Get(path)
+	if err != nil {
+		return err
+	}
+	p, locker, err := getProfileFromEncryptedStorage(path, id, oldPassword, s.cipher, s.storage)
+	if err != nil || p.ID != s.profile.ID {
+		return errors.New("invalid password")
+	}
+	if pending, err := s.profileKeyRotationPending(); err != nil || pending {
+		return errors.New("a profile key rotation must be completed or rolled back before changing the password")
+	}
+	defer zeroBytes(p.Key)
+	zeroBytes(locker.Key)
+	params := locker.KDF
+	if params.Version < currentKDFVersion {
+		params = DefaultKDFParams
+	}
+	locker, err = initProfileLocker(p, locker.Wipes, newPassword, params, s.cipher, s.storage)
+	if err != nil {
+		return err
+	}
+	// restore the previous profile if the new one can't be read back
+	if _, err := verifyProfilePassword(path, id, newPassword, s.cipher, s.storage); err != nil {
+		if _, restoreErr := s.storage.Set(path, previous); restoreErr != nil {
+			return fmt.Errorf("password change failed: %v, restore failed: %v", err, restoreErr)
+		}
+		return err
+	}
+	s.setLocker(locker)
+	return nil
+}
+
+// SetupDuressProfile takes the session password, a duress password, and a bool and creates a decoy profile
+// that opens with the duress password. The decoy is stored the same way as any other profile. Chats made
+// while logged in to the decoy give it plausible content. If wipe is true, logging in with the duress
+// password also deletes the chat data of this profile in the background. Any previous decoy profile for this
+// profile is deleted along with its chats.
+func (s *Session) SetupDuressProfile(password, duressPassword string, wipe bool) error {
+	if err := s.active(); err != nil {
+		return err
+	}
+	if duressPassword == "" || duressPassword == password {
+		return errors.New("duress password must not be empty or match the profile password")
+	}
+	id, err := s.profile.IDBytes()
+	if err != nil {
+		return errors.New("profile id failed to decode hex")
+	}
+	p, locker, err := getProfileFromEncryptedStorage(profileKeyPrefix+s.profile.ID, id, password, s.cipher, s.storage)
+	if err != nil || p.ID != s.profile.ID {
+		return errors.New("invalid password")
+	}
+	// the duress password must not open an existing profile, or logins would be ambiguous
+	opens, err := passwordOpensProfile(s.storage, s.cipher, duressPassword, p.Settings.DuressProfileID)
+	if err != nil {
+		return err
+	}
+	if opens {
+		return errors.New("duress password opens an existing profile")
+	}
+
+	// the decoy holds the names key to wipe obfuscated keys, so profiles created without one get one here
+	if len(p.NamesKey) == 0 {
+		p.NamesKey = genRandBytes(profileKeyLength)
+	}
+	decoy := generateRandomProfile()
+	var wipes []profileWipe
+	if wipe {
+		wipes = []profileWipe{{ProfileID: p.ID, NamesKey: p.NamesKey}}
+	}
+	if _, err := initProfileLocker(decoy, wipes, duressPassword, locker.KDF, s.cipher, s.storage); err != nil {
+		return err
+	}
+	previous := p.Settings.DuressProfileID
+	previousNamesKey := p.Settings.NamesKeys[previous]
+	p.Settings.DuressProfileID = decoy.ID
+	p.Settings.NamesKeys = map[string][]byte{decoy.ID: decoy.NamesKey}
+	if err := locker.lock(p, s.cipher, s.storage); err != nil {
+		deleteProfile(s.storage, s.cipher, decoy.ID, decoy.NamesKey)
+		return err
+	}
+	s.setProfile(p)
+	s.setLocker(locker)
+	if previous != "" {
+		return deleteProfile(s.storage, s.cipher, previous, previousNamesKey)
+	}
+	return nil
+}
+
+// deleteProfile takes a storage interface, cipher, profile ID, and the names key of the profile, if known, and
+// wipes the profile and all of its chat data
+func deleteProfile(s storage.Storage, cipher cipher, profileID string, namesKey []byte) error {
+	if err := wipeProfileChats(s, cipher, profileID, namesKey); err != nil {
+		return err
+	}
+	if err := wipeKey(s, rotationKeyPrefix+profileID); err != nil {
+		return err
+	}
+	return wipeKey(s, profileKeyPrefix+profileID)
+}
+
+// wipeProfileChats takes a storage interface, cipher, profile ID, and the names key of the profile, if known,
+// and wipes all chat and handshake data stored for the profile under both cleartext and obfuscated keys
+func wipeProfileChats(s storage.Storage, cipher cipher, profileID string, namesKey []byte) error {
+	keys, err := profileCleartextKeys(s, profileID)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := wipeKey(s, key); err != nil {
+			return err
+		}
+	}
+	return wipeObfuscatedKeys(s, cipher, namesKey)
+}
+
+// profileCleartextKeys takes a storage interface and profile ID and returns the cleartext keys of all chat and
+// handshake data stored for the profile, chats/<chat>/<profile>/ and handshakes/<handshake>/<profile>, and an
+// error. Data stored under obfuscated keys is not included.
+func profileCleartextKeys(s storage.Storage, profileID string) ([]string, error) {
+	var keys []string
+	for _, prefix := range []string{"chats/", handshakeKeyPrefix} {
+		list, err := s.List(prefix)
+		if err != nil {
+			return []string{}, err
+		}
+		for _, key := range list {
+			parts := strings.Split(key, "/")
+			if len(parts) > 2 && parts[2] == profileID {
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys, nil
+}
+
+// GetProfileLabel returns the display label of the session profile and an error
+func (s *Session) GetProfileLabel() (string, error) {
+	if err := s.active(); err != nil {
+		return "", err
+	}
+	return s.profile.Settings.Label, nil
+}
+
+// SetProfileLabel takes a display label and stores it encrypted in the session profile
+func (s *Session) SetProfileLabel(label string) error {
+	if err := s.active(); err != nil {
+		return err
+	}
+	if len(label) > maxProfileLabelLength {
+		return fmt.Errorf("profile label exceeds max length of %v bytes", maxProfileLabelLength)
+	}
+	p := s.profile
+	p.Settings.Label = label
+	if err := s.locker.lock(p, s.cipher, s.storage); err != nil {
+		return err
+	}
+	s.setProfile(p)
+	return nil
+}
+
+// DeleteProfile takes the session password and deletes the session profile along with all of its
+// chats/*/<profileID>/ data and its decoy profile, if one is set up. The session is locked afterwards.
+func (s *Session) DeleteProfile(password string) error {
+	if err := s.active(); err != nil {
+		return err
+	}
+	id, err := s.profile.IDBytes()
+	if err != nil {
+		return errors.New("profile id failed to decode hex")
+	}
+	profileID, err := verifyProfilePassword(profileKeyPrefix+s.profile.ID, id, password, s.cipher, s.storage)
+	if err != nil || profileID != s.profile.ID {
+		return errors.New("invalid password")
+	}
+	if duressID := s.profile.Settings.DuressProfileID; duressID != "" {
+		if err := deleteProfile(s.storage, s.cipher, duressID, s.profile.Settings.NamesKeys[duressID]); err != nil {
+			return err
+		}
+	}
+	if err := deleteProfile(s.storage, s.cipher, s.profile.ID, s.profile.NamesKey); err != nil {
+		return err
+	}
+	s.Lock()
+	return nil
+}
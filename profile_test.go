@@ -0,0 +1,193 @@
+package handshake
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/nomasters/handshake/lib/config"
+	"github.com/nomasters/handshake/lib/storage"
+)
+
+func TestChangePassword(t *testing.T) {
+	path := "passwd-handshake.boltdb"
+	os.Remove(path)
+	defer os.Remove(path)
+	oldPassword := "old password"
+	newPassword := "new password"
+
+	cfg := config.NewConfig()
+	store, err := storage.NewStorage(cfg, storage.Options{Engine: storage.BoltEngine, FilePath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := generateRandomProfile()
+	if err := initProfile(p, oldPassword, DefaultKDFParams, newTimeSeriesSBCipher(), store); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	opts := SessionOptions{StorageEngine: storage.BoltEngine, StorageFilePath: path}
+	s, err := NewSession(oldPassword, cfg, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ChangePassword("wrong password", newPassword); err == nil {
+		t.Error("ChangePassword returned no error with bad password")
+	}
+	if err := s.ChangePassword(oldPassword, ""); err == nil {
+		t.Error("ChangePassword returned no error with empty password")
+	}
+	if err := s.ChangePassword(oldPassword, newPassword); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	if _, err := NewSession(oldPassword, cfg, opts); err == nil {
+		t.Error("NewSession returned no error with old password")
+	}
+	s, err = NewSession(newPassword, cfg, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if s.GetProfile().ID != p.ID {
+		t.Errorf("expected profile %v, got %v", p.ID, s.GetProfile().ID)
+	}
+}
+
+func TestDuressProfile(t *testing.T) {
+	path := "duress-handshake.boltdb"
+	os.Remove(path)
+	defer os.Remove(path)
+	password := "real password"
+	duressPassword := "duress password"
+	chatID := "a1b2c3"
+	cfg := config.NewConfig()
+	opts := SessionOptions{StorageEngine: storage.BoltEngine, StorageFilePath: path}
+
+	s := newRotationTestSession(t, path, password, chatID)
+	realID := s.GetProfile().ID
+	realNamesKey := s.GetProfile().NamesKey
+	if err := s.SetupDuressProfile(password, password, true); err == nil {
+		t.Error("SetupDuressProfile returned no error for duress password matching password")
+	}
+	if err := s.SetupDuressProfile("junk password", duressPassword, true); err == nil {
+		t.Error("SetupDuressProfile returned no error with bad password")
+	}
+	if err := s.SetupDuressProfile(password, duressPassword, false); err != nil {
+		t.Fatal(err)
+	}
+	// setting up again replaces the previous decoy
+	if err := s.SetupDuressProfile(password, duressPassword, true); err != nil {
+		t.Fatal(err)
+	}
+	paths, err := s.storage.List(profileKeyPrefix)
+	if err != nil || len(paths) != 2 {
+		t.Fatalf("expected 2 profiles, got %v: %v", paths, err)
+	}
+	var sizes []int
+	for _, path := range paths {
+		b, _ := s.storage.Get(path)
+		sizes = append(sizes, len(b))
+	}
+	if sizes[0] != sizes[1] {
+		t.Errorf("profile sizes differ: %v", sizes)
+	}
+	s.Close()
+
+	decoy, err := NewSession(duressPassword, cfg, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoy.GetProfile().ID == realID {
+		t.Error("duress password opened the real profile")
+	}
+	// the decoy must not reveal the profile it wipes
+	decoyProfile, err := encodeGob(decoy.GetProfile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(decoyProfile, []byte(realID)) || bytes.Contains(decoyProfile, realNamesKey) {
+		t.Error("decoy profile reveals the real profile")
+	}
+	decoy.Close()
+
+	s, err = NewSession(password, cfg, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if s.GetProfile().ID != realID {
+		t.Error("password did not open the real profile")
+	}
+	if keys, err := profileCleartextKeys(s.storage, realID); err != nil || len(keys) != 0 {
+		t.Errorf("expected real profile chats to be wiped, found %v: %v", keys, err)
+	}
+}
+
+func TestMultiProfile(t *testing.T) {
+	path := "multi-handshake.boltdb"
+	os.Remove(path)
+	defer os.Remove(path)
+	cfg := config.NewConfig()
+	opts := SessionOptions{StorageEngine: storage.BoltEngine, StorageFilePath: path}
+	chatID := "a1b2c3"
+
+	s := newRotationTestSession(t, path, "alice password", chatID)
+	aliceID := s.GetProfile().ID
+	s.Close()
+
+	if err := NewProfile("bob password", "bob", cfg, opts); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewProfile("alice password", "alice", cfg, opts); err == nil {
+		t.Error("NewProfile returned no error for a password that opens an existing profile")
+	}
+	listBytes, err := ListProfiles(cfg, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list []string
+	if err := json.Unmarshal(listBytes, &list); err != nil || len(list) != 2 {
+		t.Fatalf("expected 2 profiles, got %v: %v", string(listBytes), err)
+	}
+
+	bob, err := NewSession("bob password", cfg, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if label, err := bob.GetProfileLabel(); err != nil || label != "bob" {
+		t.Errorf("expected label bob, got %v: %v", label, err)
+	}
+	if err := bob.SetProfileLabel("robert"); err != nil {
+		t.Fatal(err)
+	}
+	if err := bob.setChatLog(chatID, ChatLog{}); err != nil {
+		t.Fatal(err)
+	}
+	bobID := bob.GetProfile().ID
+	if err := bob.DeleteProfile("junk password"); err == nil {
+		t.Error("DeleteProfile returned no error with bad password")
+	}
+	if err := bob.DeleteProfile("bob password"); err != nil {
+		t.Fatal(err)
+	}
+	if keys, err := profileCleartextKeys(bob.storage, bobID); err != nil || len(keys) != 0 {
+		t.Errorf("expected deleted profile chats to be removed, found %v: %v", keys, err)
+	}
+	if keys, err := profileCleartextKeys(bob.storage, aliceID); err != nil || len(keys) != 3 {
+		t.Errorf("expected other profile chats to remain, found %v: %v", keys, err)
+	}
+	bob.Close()
+
+	if _, err := NewSession("bob password", cfg, opts); err == nil {
+		t.Error("NewSession returned no error for a deleted profile")
+	}
+	alice, err := NewSession("alice password", cfg, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice.Close()
+}
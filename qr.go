@@ -0,0 +1,180 @@
+package handshake
+
+import (
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	qrcode "github.com/skip2/go-qrcode"
+	"golang.org/x/crypto/blake2b"
+)
+
+const (
+	// shareFrameVersion prefixes every share frame and is bumped if the frame format changes
+	shareFrameVersion = "HS1"
+	// DefaultShareFrameSize is the number of share bytes carried by each frame, which keeps each QR code
+	// small enough to scan reliably from a phone or a terminal
+	DefaultShareFrameSize = 256
+	// shareChecksumLength is the number of bytes of the blake2b-256 hash of a share included in each frame
+	shareChecksumLength = 4
+	// DefaultQRCodePNGSize is the default width and height in pixels of a QR code PNG
+	DefaultQRCodePNGSize = 512
+	// maxShareFrames is the most frames a share is split into, or reassembled from, which is enough to carry a
+	// share of maxMessageSize in frames of DefaultShareFrameSize
+	maxShareFrames = (maxMessageSize + DefaultShareFrameSize - 1) / DefaultShareFrameSize
+)
+
+// frames use base32 with uppercase hex checksums, so that every character falls in the QR alphanumeric set
+var shareFrameEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// ErrIncompleteShare is returned when the bytes of a share are requested before all of its frames are added
+var ErrIncompleteShare = errors.New("share frames incomplete")
+
+// shareChecksum takes a share and returns the uppercase hex checksum included in each of its frames
+func shareChecksum(share []byte) string {
+	sum := blake2b.Sum256(share)
+	return strings.ToUpper(hex.EncodeToString(sum[:shareChecksumLength]))
+}
+
+// SplitShare takes a share, such as the output of ShareHandshakePosition or GetHandshakePeerConfig, and the
+// number of bytes to carry per frame, and returns numbered frames of the form HS1/<index>/<total>/<checksum>/<data>
+// to be rendered as QR codes. The frames can be joined in any order with JoinShareFrames.
+func SplitShare(share []byte, frameSize int) ([]string, error) {
+	if len(share) == 0 {
+		return []string{}, errors.New("share is empty")
+	}
+	if frameSize <= 0 {
+		return []string{}, errors.New("frameSize must be greater than 0")
+	}
+	sum := shareChecksum(share)
+	total := (len(share) + frameSize - 1) / frameSize
+	if total > maxShareFrames {
+		return []string{}, fmt.Errorf("share would need %v frames, more than the max of %v", total, maxShareFrames)
+	}
+	frames := make([]string, 0, total)
+	for i := 0; i < total; i++ {
+		end := (i + 1) * frameSize
+		if end > len(share) {
+			end = len(share)
+		}
+		data := shareFrameEncoding.EncodeToString(share[i*frameSize : end])
+		frames = append(frames, fmt.Sprintf("%v/%v/%v/%v/%v", shareFrameVersion, i+1, total, sum, data))
+	}
+	return frames, nil
+}
+
+// IsShareFrame returns true if s looks like a share frame rather than another share encoding
+func IsShareFrame(s string) bool {
+	return strings.HasPrefix(strings.TrimSpace(s), shareFrameVersion+"/")
+}
+
+// ShareFrames reassembles a share from frames scanned in any order
+type ShareFrames struct {
+	total    int
+	checksum string
+	data     map[int][]byte
+}
+
+// NewShareFrames returns an empty ShareFrames
+func NewShareFrames() *ShareFrames {
+	return &ShareFrames{data: make(map[int][]byte)}
+}
+
+// Add takes a frame string and adds it to the share. It returns a bool and an error. The bool indicates if
+// every frame of the share has been added. Frames from a different share, or that don't decode, are rejected.
+// Adding the same frame twice is allowed, so that a frame can safely be scanned again.
+func (f *ShareFrames) Add(frame string) (bool, error) {
+	parts := strings.SplitN(strings.TrimSpace(frame), "/", 5)
+	if len(parts) != 5 || parts[0] != shareFrameVersion {
+		return false, errors.New("invalid share frame")
+	}
+	index, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return false, fmt.Errorf("invalid share frame index: %v", parts[1])
+	}
+	total, err := strconv.Atoi(parts[2])
+	if err != nil || total <= 0 || total > maxShareFrames {
+		return false, fmt.Errorf("invalid share frame total: %v", parts[2])
+	}
+	if index <= 0 || index > total {
+		return false, fmt.Errorf("share frame %v is out of range of %v frames", index, total)
+	}
+	checksum := strings.ToUpper(parts[3])
+	if len(checksum) != hex.EncodedLen(shareChecksumLength) {
+		return false, fmt.Errorf("invalid share frame checksum: %v", parts[3])
+	}
+	if f.total != 0 && (f.total != total || f.checksum != checksum) {
+		return false, errors.New("share frame belongs to a different share")
+	}
+	data, err := shareFrameEncoding.DecodeString(strings.ToUpper(parts[4]))
+	if err != nil || len(data) == 0 {
+		return false, fmt.Errorf("share frame %v of %v is corrupt", index, total)
+	}
+	if existing, ok := f.data[index]; ok && string(existing) != string(data) {
+		return false, fmt.Errorf("share frame %v of %v conflicts with an earlier scan", index, total)
+	}
+	f.total = total
+	f.checksum = checksum
+	f.data[index] = data
+	return f.Complete(), nil
+}
+
+// Complete returns true if every frame of the share has been added
+func (f *ShareFrames) Complete() bool {
+	return f.total > 0 && len(f.data) == f.total
+}
+
+// Missing returns the sorted indexes of frames that haven't been added yet
+func (f *ShareFrames) Missing() []int {
+	var missing []int
+	for i := 1; i <= f.total; i++ {
+		if _, ok := f.data[i]; !ok {
+			missing = append(missing, i)
+		}
+	}
+	return missing
+}
+
+// Bytes returns the reassembled share and an error. ErrIncompleteShare is returned until every frame has been
+// added, and an error is returned if the share doesn't match the checksum carried by its frames.
+func (f *ShareFrames) Bytes() ([]byte, error) {
+	if !f.Complete() {
+		return []byte{}, ErrIncompleteShare
+	}
+	var share []byte
+	for i := 1; i <= f.total; i++ {
+		share = append(share, f.data[i]...)
+	}
+	if shareChecksum(share) != f.checksum {
+		return []byte{}, errors.New("share failed integrity check")
+	}
+	return share, nil
+}
+
+// JoinShareFrames takes the frames returned by SplitShare, in any order, and returns the share and an error
+func JoinShareFrames(frames []string) ([]byte, error) {
+	f := NewShareFrames()
+	for _, frame := range frames {
+		if _, err := f.Add(frame); err != nil {
+			return []byte{}, err
+		}
+	}
+	return f.Bytes()
+}
+
+// QRCodeString takes a frame and returns it rendered as a QR code for display in a terminal
+func QRCodeString(frame string) (string, error) {
+	q, err := qrcode.New(frame, qrcode.Medium)
+	if err != nil {
+		return "", err
+	}
+	return q.ToSmallString(false), nil
+}
+
+// QRCodePNG takes a frame and a width and height in pixels and returns it rendered as a QR code PNG
+func QRCodePNG(frame string, size int) ([]byte, error) {
+	return qrcode.Encode(frame, qrcode.Medium, size)
+}
@@ -0,0 +1,97 @@
+package handshake
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestShareFrames(t *testing.T) {
+	h := newHandshakePeerWithDefaults()
+	share, err := h.Position.Share()
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames, err := SplitShare(share, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) < 3 {
+		t.Fatalf("expected the share to be split into several frames, got %v", len(frames))
+	}
+	for _, frame := range frames {
+		if !IsShareFrame(frame) {
+			t.Errorf("expected a share frame: %v", frame)
+		}
+		if _, err := QRCodeString(frame); err != nil {
+			t.Error(err)
+		}
+		if b, err := QRCodePNG(frame, DefaultQRCodePNGSize); err != nil || !bytes.HasPrefix(b, []byte("\x89PNG")) {
+			t.Errorf("expected a PNG: %v", err)
+		}
+	}
+
+	// frames are scanned out of order, and one is scanned twice
+	f := NewShareFrames()
+	for i := len(frames) - 1; i >= 0; i-- {
+		if i == 0 {
+			if _, err := f.Bytes(); err != ErrIncompleteShare {
+				t.Errorf("expected ErrIncompleteShare, got %v", err)
+			}
+			if missing := f.Missing(); len(missing) != 1 || missing[0] != 1 {
+				t.Errorf("expected frame 1 to be missing, got %v", missing)
+			}
+		}
+		if _, err := f.Add(frames[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if complete, err := f.Add(frames[1]); err != nil || !complete {
+		t.Errorf("expected a rescanned frame to be accepted: %v", err)
+	}
+	joined, err := f.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(joined, share) {
+		t.Error("joined share does not match")
+	}
+
+	other, err := SplitShare(append([]byte{0}, share...), 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := JoinShareFrames([]string{frames[0], other[1]}); err == nil {
+		t.Error("expected frames of different shares to be rejected")
+	}
+	if _, err := JoinShareFrames(frames[1:]); err != ErrIncompleteShare {
+		t.Errorf("expected ErrIncompleteShare, got %v", err)
+	}
+
+	// swapping the data of two frames keeps each frame valid but fails the share checksum
+	swapped := append([]string{}, frames...)
+	first := strings.SplitN(frames[0], "/", 5)
+	second := strings.SplitN(frames[1], "/", 5)
+	first[4], second[4] = second[4], first[4]
+	swapped[0] = strings.Join(first, "/")
+	swapped[1] = strings.Join(second, "/")
+	if _, err := JoinShareFrames(swapped); err == nil {
+		t.Error("expected reordered frame data to fail the integrity check")
+	}
+	if _, err := JoinShareFrames([]string{"HS1/1/1/00000000/!!!"}); err == nil {
+		t.Error("expected a corrupt frame to be rejected")
+	}
+
+	// a frame can't claim more frames than any share needs, so Missing stays bounded
+	huge := NewShareFrames()
+	if _, err := huge.Add(fmt.Sprintf("HS1/1/%v/00000000/AA", maxShareFrames+1)); err == nil {
+		t.Error("expected a frame total above the max to be rejected")
+	}
+	if missing := huge.Missing(); len(missing) != 0 {
+		t.Errorf("expected no missing frames, got %v", len(missing))
+	}
+	if _, err := SplitShare(make([]byte, maxShareFrames+1), 1); err == nil {
+		t.Error("expected a split into more than the max frames to be rejected")
+	}
+}
@@ -0,0 +1,179 @@
+package handshake
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+)
+
+// rotationKeyPrefix is the prefix used for the profile key rotation progress keys
+const rotationKeyPrefix = "rotations/"
+
+// keyRotation records a profile key rotation in progress. It is stored encrypted with the profileLocker key
+// under rotations/<id> until the rotation is completed or rolled back.
+type keyRotation struct {
+	OldKey []byte
+	NewKey []byte
+}
+
+// takes gob encoded byte slice and returns a keyRotation and error
+func newKeyRotationFromGob(b []byte) (keyRotation, error) {
+	var r keyRotation
+	var buffer bytes.Buffer
+	buffer.Write(b)
+	err := gob.NewDecoder(&buffer).Decode(&r)
+	return r, err
+}
+
+// RotateProfileKey replaces Profile.Key with a new random key and re-encrypts all data stored for the profile
+// under chats/<chat>/<profile>/. The rotation is recorded before any data is re-encrypted, so if it is
+// interrupted, calling RotateProfileKey again completes it and RollbackProfileKeyRotation reverts it. Until then,
+// chat data may be unreadable.
+func (s *Session) RotateProfileKey() error {
+	if err := s.active(); err != nil {
+		return err
+	}
+	r, pending, err := s.getKeyRotation()
+	if err != nil {
+		return err
+	}
+	if !pending {
+		r = keyRotation{
+			OldKey: s.profile.Key,
+			NewKey: genRandBytes(profileKeyLength),
+		}
+		if err := s.setKeyRotation(r); err != nil {
+			return err
+		}
+	}
+	// all keys are visited, since data written while the rotation was pending is encrypted with the old key
+	keys, err := s.profileDataKeys()
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := s.reencrypt(key, r.OldKey, r.NewKey); err != nil {
+			return err
+		}
+	}
+	if err := s.finishKeyRotation(r.NewKey); err != nil {
+		return err
+	}
+	zeroBytes(r.OldKey)
+	return nil
+}
+
+// RollbackProfileKeyRotation reverts an interrupted profile key rotation, re-encrypting any rotated data with
+// the previous Profile.Key.
+func (s *Session) RollbackProfileKeyRotation() error {
+	if err := s.active(); err != nil {
+		return err
+	}
+	r, pending, err := s.getKeyRotation()
+	if err != nil {
+		return err
+	}
+	if !pending {
+		return errors.New("no profile key rotation in progress")
+	}
+	// all keys are visited, since a key may have been re-encrypted before its progress was recorded
+	keys, err := s.profileDataKeys()
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := s.reencrypt(key, r.NewKey, r.OldKey); err != nil {
+			return err
+		}
+	}
+	if err := s.finishKeyRotation(r.OldKey); err != nil {
+		return err
+	}
+	zeroBytes(r.NewKey)
+	return nil
+}
+
+// ProfileKeyRotationPending returns true if a profile key rotation was interrupted and an error
+func (s *Session) ProfileKeyRotationPending() (bool, error) {
+	if err := s.active(); err != nil {
+		return false, err
+	}
+	return s.profileKeyRotationPending()
+}
+
+func (s *Session) profileKeyRotationPending() (bool, error) {
+	_, pending, err := s.getKeyRotation()
+	return pending, err
+}
+
+// finishKeyRotation stores the profile with the key, sets it on the session, and removes the rotation record
+func (s *Session) finishKeyRotation(key []byte) error {
+	p := s.profile
+	p.Key = key
+	if err := s.locker.lock(p, s.cipher, s.storage); err != nil {
+		return err
+	}
+	s.setProfile(p)
+	return s.storage.Delete(rotationKeyPrefix + s.profile.ID)
+}
+
+// reencrypt takes a storage key and re-encrypts its value from one key to another. Values that already
+// decrypt with the new key are left as is, so an interrupted rotation can visit every key again.
+func (s *Session) reencrypt(key string, from, to []byte) error {
+	encrypted, err := s.storage.Get(s.storageKey(key))
+	if err != nil {
+		return err
+	}
+	value, err := s.cipher.Decrypt(encrypted, from)
+	if err != nil {
+		if _, toErr := s.cipher.Decrypt(encrypted, to); toErr == nil {
+			return nil
+		}
+		return err
+	}
+	if encrypted, err = s.cipher.Encrypt(value, to); err != nil {
+		return err
+	}
+	_, err = s.storage.Set(s.storageKey(key), encrypted)
+	return err
+}
+
+// profileDataKeys returns the logical paths of all data encrypted with Profile.Key and an error
+func (s *Session) profileDataKeys() ([]string, error) {
+	if s.obfuscated() {
+		return s.list("")
+	}
+	return profileCleartextKeys(s.storage, s.profile.ID)
+}
+
+// getKeyRotation returns the keyRotation for the profile, a bool that is true if one is in progress, and an error
+func (s *Session) getKeyRotation() (keyRotation, bool, error) {
+	encrypted, err := s.storage.Get(rotationKeyPrefix + s.profile.ID)
+	if err != nil {
+		return keyRotation{}, false, err
+	}
+	if len(encrypted) == 0 {
+		return keyRotation{}, false, nil
+	}
+	b, err := s.cipher.Decrypt(encrypted, s.locker.Key)
+	if err != nil {
+		return keyRotation{}, false, err
+	}
+	defer zeroBytes(b)
+	r, err := newKeyRotationFromGob(b)
+	return r, true, err
+}
+
+func (s *Session) setKeyRotation(r keyRotation) error {
+	b, err := encodeGob(r)
+	if err != nil {
+		return err
+	}
+	defer zeroBytes(b)
+	encrypted, err := s.cipher.Encrypt(b, s.locker.Key)
+	if err != nil {
+		return err
+	}
+	_, err = s.storage.Set(rotationKeyPrefix+s.profile.ID, encrypted)
+	return err
+}
@@ -0,0 +1,143 @@
+package handshake
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/nomasters/handshake/lib/config"
+	"github.com/nomasters/handshake/lib/storage"
+)
+
+// newRotationTestSession returns a Session for a new profile stored at path with chat data for chatID
+func newRotationTestSession(t *testing.T, path, password, chatID string) *Session {
+	cfg := config.NewConfig()
+	store, err := storage.NewStorage(cfg, storage.Options{Engine: storage.BoltEngine, FilePath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := initProfile(generateRandomProfile(), password, DefaultKDFParams, newTimeSeriesSBCipher(), store); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+	s, err := NewSession(password, cfg, SessionOptions{StorageEngine: storage.BoltEngine, StorageFilePath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.setChat(chatID, chat{ID: chatID, PeerID: "peer"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.setChatLog(chatID, ChatLog{"hash": ChatLogEntry{ID: "hash"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.setLookup(chatID, "peer", lookup{"lookup": []byte("key")}); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// checkRotationTestData fails the test if the chat data for chatID can't be read with the session key
+func checkRotationTestData(t *testing.T, s *Session, chatID string) {
+	if c, err := s.getChat(chatID); err != nil || c.PeerID != "peer" {
+		t.Errorf("chat config could not be read: %v", err)
+	}
+	if cl, err := s.GetChatLog(chatID); err != nil || cl["hash"].ID != "hash" {
+		t.Errorf("chat log could not be read: %v", err)
+	}
+	if l, err := s.getLookup(chatID, "peer"); err != nil || !bytes.Equal(l["lookup"], []byte("key")) {
+		t.Errorf("lookup could not be read: %v", err)
+	}
+}
+
+func TestRotateProfileKey(t *testing.T) {
+	path := "rotate-handshake.boltdb"
+	os.Remove(path)
+	defer os.Remove(path)
+	password := "rotate password"
+	chatID := "a1b2c3"
+
+	s := newRotationTestSession(t, path, password, chatID)
+	oldKey := s.GetProfile().Key
+	if err := s.RotateProfileKey(); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(oldKey, s.GetProfile().Key) {
+		t.Error("profile key was not rotated")
+	}
+	checkRotationTestData(t, s, chatID)
+	if err := s.RollbackProfileKeyRotation(); err == nil {
+		t.Error("RollbackProfileKeyRotation returned no error without a rotation in progress")
+	}
+	newKey := append([]byte{}, s.GetProfile().Key...)
+	s.Close()
+
+	s, err := NewSession(password, config.NewConfig(), SessionOptions{StorageEngine: storage.BoltEngine, StorageFilePath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if !bytes.Equal(newKey, s.GetProfile().Key) {
+		t.Error("rotated profile key was not stored")
+	}
+	checkRotationTestData(t, s, chatID)
+}
+
+func TestInterruptedProfileKeyRotation(t *testing.T) {
+	path := "interrupted-handshake.boltdb"
+	os.Remove(path)
+	defer os.Remove(path)
+	password := "rotate password"
+	chatID := "a1b2c3"
+
+	s := newRotationTestSession(t, path, password, chatID)
+	defer s.Close()
+	oldKey := s.GetProfile().Key
+
+	// interrupt a rotation after two keys are re-encrypted, then write one of them with the old key as a
+	// session does while the rotation is pending
+	interrupt := func() {
+		r := keyRotation{OldKey: oldKey, NewKey: genRandBytes(profileKeyLength)}
+		if err := s.setKeyRotation(r); err != nil {
+			t.Fatal(err)
+		}
+		keys, err := s.profileDataKeys()
+		if err != nil || len(keys) != 3 {
+			t.Fatalf("expected 3 profile data keys, got %v: %v", keys, err)
+		}
+		for _, key := range keys[:2] {
+			if err := s.reencrypt(key, r.OldKey, r.NewKey); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := s.reencrypt(keys[0], r.NewKey, r.OldKey); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	interrupt()
+	if pending, err := s.ProfileKeyRotationPending(); err != nil || !pending {
+		t.Fatalf("expected pending rotation: %v", err)
+	}
+	if err := s.ChangePassword(password, "new password"); err == nil {
+		t.Error("ChangePassword returned no error with a rotation in progress")
+	}
+	if err := s.RollbackProfileKeyRotation(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(oldKey, s.GetProfile().Key) {
+		t.Error("profile key changed after rollback")
+	}
+	checkRotationTestData(t, s, chatID)
+
+	interrupt()
+	if err := s.RotateProfileKey(); err != nil {
+		t.Fatal(err)
+	}
+	if pending, err := s.ProfileKeyRotationPending(); err != nil || pending {
+		t.Errorf("expected no pending rotation: %v", err)
+	}
+	if bytes.Equal(oldKey, s.GetProfile().Key) {
+		t.Error("profile key was not rotated")
+	}
+	checkRotationTestData(t, s, chatID)
+}
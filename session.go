@@ -4,16 +4,25 @@ import (
 	"bytes"
 	"encoding/base64"
 	"encoding/gob"
-	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/nomasters/handshake/lib/config"
 	"github.com/nomasters/handshake/lib/storage"
 )
 
+// ErrSessionExpired is returned by Session methods once the Session TTL or idle timeout has elapsed
+var ErrSessionExpired = errors.New("session expired")
+
+// ErrSessionLocked is returned by Session methods while the Session is locked
+var ErrSessionLocked = errors.New("session locked")
+
+// ErrMessageTooLarge is returned when an encoded message doesn't fit in a single IPFS block
+var ErrMessageTooLarge = errors.New("message too large")
+
 const (
 	// DefaultSessionTTL is the default TTL before a Session closes
 	DefaultSessionTTL  = 15 * 60 // 15 minutes in seconds
@@ -31,51 +40,105 @@ type Session struct {
 	startTime       int64
 	globalConfig    config.Config
 	activeHandshake *handshake
+	locker          profileLocker
+	idleTimeout     int64
+	lastActive      int64
+	locked          bool
+	expired         bool
+	wipes           sync.WaitGroup
+	keys            keyring
+	index           keyIndex
 }
 
 // SessionOptions holds session options for initialization
 type SessionOptions struct {
 	StorageEngine   storage.Engine
 	StorageFilePath string
+	// IdleTimeout is the number of seconds without activity before a Session expires. Zero disables it.
+	IdleTimeout int64
+	// SecureDelete compacts device storage when the Session is closed so consumed keys are not left on disk
+	SecureDelete bool
 }
 
 // NewSession takes a password and opts and returns a pointer to Session and an error
 func NewSession(password string, cfg config.Config, opts SessionOptions) (*Session, error) {
 	storageOpts := storage.Options{Engine: opts.StorageEngine}
 	storageOpts.FilePath = opts.StorageFilePath
+	storageOpts.SecureDelete = opts.SecureDelete
 	storage, err := storage.NewStorage(cfg, storageOpts)
 	if err != nil {
 		return nil, err
 	}
 
 	cipher := newTimeSeriesSBCipher()
+	now := time.Now().Unix()
 	session := Session{
-		storage:   storage,
-		cipher:    cipher,
-		ttl:       DefaultSessionTTL,
-		startTime: time.Now().Unix(),
+		storage:      storage,
+		cipher:       cipher,
+		ttl:          DefaultSessionTTL,
+		startTime:    now,
+		lastActive:   now,
+		idleTimeout:  opts.IdleTimeout,
+		globalConfig: cfg,
+	}
+
+	loginConfig, err := getLoginConfig(storage, cfg)
+	if err != nil {
+		storage.Close()
+		return nil, err
+	}
+	if err := checkLoginLockout(loginConfig); err != nil {
+		storage.Close()
+		return nil, err
 	}
 
 	profilePaths, err := storage.List(profileKeyPrefix)
 	if err != nil {
+		storage.Close()
 		return nil, err
 	}
 	if len(profilePaths) == 0 {
+		storage.Close()
 		return nil, errors.New("no profile found")
 	}
 	for _, profilePath := range profilePaths {
 		id, err := getIDFromPath(profilePath)
 		if err != nil {
+			storage.Close()
 			return nil, err
 		}
-		key := deriveKey([]byte(password), id)
-		profile, err := getProfileFromEncryptedStorage(profilePath, key, cipher, storage)
-		if err == nil {
-			session.setProfile(profile)
-			return &session, err
+		profile, locker, err := getProfileFromEncryptedStorage(profilePath, id, password, cipher, storage)
+		if err != nil {
+			continue
+		}
+		// profiles stored with older KDF parameters are upgraded to the defaults
+		if locker.KDF.Version < currentKDFVersion {
+			legacyKey := locker.Key
+			locker, err = initProfileLocker(profile, locker.Wipes, password, DefaultKDFParams, cipher, storage)
+			zeroBytes(legacyKey)
+			if err != nil {
+				storage.Close()
+				return nil, err
+			}
 		}
+		if err := resetFailedLogins(storage, loginConfig); err != nil {
+			storage.Close()
+			return nil, err
+		}
+		session.setProfile(profile)
+		session.setLocker(locker)
+		if profile.Settings.SessionTTL > 0 {
+			session.ttl = profile.Settings.SessionTTL
+		}
+		session.wipeOnLogin(locker.Wipes)
+		return &session, nil
 	}
 
+	err = recordFailedLogin(storage, loginConfig)
+	storage.Close()
+	if err != nil {
+		return nil, err
+	}
 	return nil, errors.New("invalid password")
 }
 
@@ -87,9 +150,25 @@ func NewDefaultSession(password string) (*Session, error) {
 	return NewSession(password, cfg, opts)
 }
 
-// setProfile takes a profile and sets it to the private variable in the Session struct
+// setProfile takes a profile and sets it to the private variable in the Session struct. The profile keys
+// are tracked by the session keyring.
 func (s *Session) setProfile(p Profile) {
+	s.keys.add(p.Key)
+	s.keys.add(p.NamesKey)
+	for _, key := range p.Settings.NamesKeys {
+		s.keys.add(key)
+	}
 	s.profile = p
+	s.index = nil
+}
+
+// setLocker takes a profileLocker and sets it on the Session. The locker keys are tracked by the session keyring.
+func (s *Session) setLocker(l profileLocker) {
+	s.keys.add(l.Key)
+	for _, w := range l.Wipes {
+		s.keys.add(w.NamesKey)
+	}
+	s.locker = l
 }
 
 // GetProfile returns the profile in the Session struct
@@ -97,39 +176,195 @@ func (s *Session) GetProfile() Profile {
 	return s.profile
 }
 
-// Close gracefully closes the session
+// Close gracefully closes the session. It waits for any background wipes to finish and wipes all key
+// material held by the session.
 func (s *Session) Close() error {
+	s.wipes.Wait()
+	s.Lock()
 	return s.storage.Close()
 }
 
+// Compact rewrites device storage so deleted and overwritten values, such as used one-time keys, are
+// removed from disk. An error is returned if the storage engine does not support compaction.
+func (s *Session) Compact() error {
+	if err := s.active(); err != nil {
+		return err
+	}
+	s.wipes.Wait()
+	c, ok := s.storage.(storage.Compactor)
+	if !ok {
+		return errors.New("storage does not support compaction")
+	}
+	return c.Compact()
+}
+
+// wipeOnLogin takes profileWipes and wipes the chat data of their profiles in the background
+func (s *Session) wipeOnLogin(wipes []profileWipe) {
+	for _, w := range wipes {
+		// the names key is copied, as the session keyring zeroes the original on Lock
+		namesKey := append([]byte{}, w.NamesKey...)
+		s.wipes.Add(1)
+		go func(id string, namesKey []byte) {
+			defer s.wipes.Done()
+			defer zeroBytes(namesKey)
+			wipeProfileChats(s.storage, s.cipher, id, namesKey)
+		}(w.ProfileID, namesKey)
+	}
+}
+
+// Lock zeroes the profile key and the active handshake held in memory without closing storage. Session methods
+// return ErrSessionLocked until Unlock is called, after which the handshake can be picked up with ResumeHandshake.
+func (s *Session) Lock() {
+	if s.activeHandshake != nil {
+		s.activeHandshake.wipe()
+		s.activeHandshake = nil
+	}
+	s.keys.wipe()
+	s.profile.Key = nil
+	s.profile.NamesKey = nil
+	s.locker.Key = nil
+	s.index = nil
+	s.locked = true
+}
+
+// Unlock takes a password and restores the profile key of a locked or expired Session. A successful
+// Unlock restarts the Session TTL. Failed attempts count towards the global config login attempts.
+func (s *Session) Unlock(password string) error {
+	cfg, err := getLoginConfig(s.storage, s.globalConfig)
+	if err != nil {
+		return err
+	}
+	if err := checkLoginLockout(cfg); err != nil {
+		return err
+	}
+	id, err := s.profile.IDBytes()
+	if err != nil {
+		return errors.New("profile id failed to decode hex")
+	}
+	p, locker, err := getProfileFromEncryptedStorage(profileKeyPrefix+s.profile.ID, id, password, s.cipher, s.storage)
+	if err != nil || p.ID != s.profile.ID {
+		if err := recordFailedLogin(s.storage, cfg); err != nil {
+			return err
+		}
+		return errors.New("invalid password")
+	}
+	if err := resetFailedLogins(s.storage, cfg); err != nil {
+		return err
+	}
+	s.setProfile(p)
+	s.setLocker(locker)
+	s.locked = false
+	s.expired = false
+	s.startTime = time.Now().Unix()
+	s.lastActive = s.startTime
+	return nil
+}
+
+// active returns ErrSessionLocked or ErrSessionExpired if the Session can't be used, locking the Session
+// once it expires. Otherwise it records activity for the idle timeout and returns nil.
+func (s *Session) active() error {
+	if s.locked {
+		if s.expired {
+			return ErrSessionExpired
+		}
+		return ErrSessionLocked
+	}
+	now := time.Now().Unix()
+	if (s.ttl > 0 && now >= s.startTime+s.ttl) || (s.idleTimeout > 0 && now >= s.lastActive+s.idleTimeout) {
+		s.Lock()
+		s.expired = true
+		return ErrSessionExpired
+	}
+	s.lastActive = now
+	return nil
+}
+
 // NewInitiatorWithDefaults provides a simple method with no arguments to create a default handshake
 // for an initiator. Adds this handshake pointer to the ActiveHandshake in the session.
-func (s *Session) NewInitiatorWithDefaults() {
-	s.activeHandshake = newHandshakeInitiatorWithDefaults()
+func (s *Session) NewInitiatorWithDefaults() error {
+	if err := s.active(); err != nil {
+		return err
+	}
+	return s.startHandshake(newHandshakeInitiatorWithDefaults())
 }
 
 // NewPeerWithDefaults provides a simple method with no arguments to create a default handshake
 // for an peer. Adds this handshake pointer to the ActiveHandshake in the session.
-func (s *Session) NewPeerWithDefaults() {
-	s.activeHandshake = newHandshakePeerWithDefaults()
+func (s *Session) NewPeerWithDefaults() error {
+	if err := s.active(); err != nil {
+		return err
+	}
+	return s.startHandshake(newHandshakePeerWithDefaults())
+}
+
+// NewInitiatorWithCipher creates a default handshake for an initiator that negotiates the cipher for
+// the CipherType. Adds this handshake pointer to the ActiveHandshake in the session.
+func (s *Session) NewInitiatorWithCipher(t CipherType) error {
+	if err := s.active(); err != nil {
+		return err
+	}
+	strategy, err := newDefaultStrategyWithCipher(t)
+	if err != nil {
+		return err
+	}
+	return s.startHandshake(newHandshake(strategy, handshakeOptions{Role: initiator}))
+}
+
+// NewPeerWithCipher creates a default handshake for a peer that negotiates the cipher for the CipherType.
+// Adds this handshake pointer to the ActiveHandshake in the session.
+func (s *Session) NewPeerWithCipher(t CipherType) error {
+	if err := s.active(); err != nil {
+		return err
+	}
+	strategy, err := newDefaultStrategyWithCipher(t)
+	if err != nil {
+		return err
+	}
+	return s.startHandshake(newHandshake(strategy, handshakeOptions{Role: peer}))
 }
 
-// ShareHandshakePosition returns the values from negotiator.Share() from the ActiveHandshake
+// ShareHandshakePosition returns the peerConfig of the ActiveHandshake position as a compact binary share, which
+// EncodeShareText turns into text that is easy to read aloud and type. If the handshake has a PIN, the share is
+// wrapped with it.
 func (s *Session) ShareHandshakePosition() (b []byte, err error) {
-	// TODO: add encryption wrapper
-	return s.activeHandshake.Position.Share()
+	if err := s.active(); err != nil {
+		return []byte{}, err
+	}
+	if err := s.pendingHandshake(); err != nil {
+		return []byte{}, err
+	}
+	config, err := s.activeHandshake.Position.PeerConfig()
+	if err != nil {
+		return []byte{}, err
+	}
+	share, err := encodePeerConfigShare(config)
+	if err != nil {
+		return []byte{}, err
+	}
+	return s.wrapShare(share)
 }
 
-// AddPeerToHandshake takes a json encoded peerConfig, attempts to unmarshal it and add it as a peer.
-// It returns a bool and an error. The bool indicates if handshake.AllPeersReceived == true, in which case
-// the handshake can safely be converted int a chat.
+// AddPeerToHandshake takes a peerConfig encoded as a binary share, as the text form of a share, or as json, and
+// adds it as a peer. It returns a bool and an error. The bool indicates if handshake.AllPeersReceived == true, in
+// which case the handshake can safely be converted int a chat. A ShareError is returned for corrupt input,
+// ErrSharePINRequired or ErrInvalidSharePIN if the share is PIN wrapped and the handshake PIN is missing or wrong,
+// and ErrShareNotWrapped if the handshake has a PIN and the share is not wrapped with it.
 func (s *Session) AddPeerToHandshake(body []byte) (bool, error) {
-	// TODO: add decryption wrapper
-	var config peerConfig
-	if err := json.Unmarshal(body, &config); err != nil {
+	if err := s.active(); err != nil {
+		return false, err
+	}
+	if err := s.pendingHandshake(); err != nil {
 		return false, err
 	}
-	if err := s.activeHandshake.AddPeer(config); err != nil {
+	body, err := s.unwrapShare(body)
+	if err != nil {
+		return false, err
+	}
+	config, err := decodePeerConfigShare(body)
+	if err != nil {
+		return false, err
+	}
+	if err := s.saveActiveHandshake(s.activeHandshake.AddPeer(config)); err != nil {
 		return false, err
 	}
 	return s.activeHandshake.AllPeersReceived(), nil
@@ -137,13 +372,23 @@ func (s *Session) AddPeerToHandshake(body []byte) (bool, error) {
 
 // GetHandshakePeerTotal returns an int count of the number of peers to expect for a handshake
 func (s *Session) GetHandshakePeerTotal() int {
+	if s.activeHandshake == nil {
+		return 0
+	}
 	return s.activeHandshake.GetPeerTotal()
 }
 
-// GetHandshakePeerConfig returns the json bytes encoded peerConfig based on peerID or and an error
+// GetHandshakePeerConfig returns the peerConfig with the sort number as a binary share, wrapped with the handshake
+// PIN if it has one, and an error
 func (s *Session) GetHandshakePeerConfig(sortNumber int) ([]byte, error) {
+	if err := s.active(); err != nil {
+		return []byte{}, err
+	}
+	if err := s.pendingHandshake(); err != nil {
+		return []byte{}, err
+	}
 	configs, err := s.activeHandshake.GetAllConfigs()
-	if err != nil {
+	if err := s.saveActiveHandshake(err); err != nil {
 		return []byte{}, err
 	}
 	if sortNumber <= 0 {
@@ -152,7 +397,61 @@ func (s *Session) GetHandshakePeerConfig(sortNumber int) ([]byte, error) {
 	if sortNumber > len(configs) {
 		return []byte{}, errors.New("sortNumber is out of range")
 	}
-	return json.Marshal(configs[sortNumber-1])
+	share, err := encodePeerConfigShare(configs[sortNumber-1])
+	if err != nil {
+		return []byte{}, err
+	}
+	return s.wrapShare(share)
+}
+
+// GetAllHandshakePeerConfigs returns the list of every peerConfig in the handshake, including their sort order, as
+// a binary share, wrapped with the handshake PIN if it has one, and an error. The initiator hands the list to each
+// peer once all peers have been added, so that every peer can build the same sorted negotiator list.
+func (s *Session) GetAllHandshakePeerConfigs() ([]byte, error) {
+	if err := s.active(); err != nil {
+		return []byte{}, err
+	}
+	if err := s.pendingHandshake(); err != nil {
+		return []byte{}, err
+	}
+	configs, err := s.activeHandshake.GetAllConfigs()
+	if err := s.saveActiveHandshake(err); err != nil {
+		return []byte{}, err
+	}
+	share, err := encodePeerConfigListShare(configs)
+	if err != nil {
+		return []byte{}, err
+	}
+	return s.wrapShare(share)
+}
+
+// AddPeersToHandshake takes a list of peerConfigs, as returned by GetAllHandshakePeerConfigs, encoded as a binary
+// share, as the text form of a share, or as json, and adds each as a peer. It returns a bool and an error. The bool
+// indicates if handshake.AllPeersReceived == true. Errors are returned as for AddPeerToHandshake.
+func (s *Session) AddPeersToHandshake(body []byte) (bool, error) {
+	if err := s.active(); err != nil {
+		return false, err
+	}
+	if err := s.pendingHandshake(); err != nil {
+		return false, err
+	}
+	body, err := s.unwrapShare(body)
+	if err != nil {
+		return false, err
+	}
+	configs, err := decodePeerConfigListShare(body)
+	if err != nil {
+		return false, err
+	}
+	for _, config := range configs {
+		if err = s.activeHandshake.AddPeer(config); err != nil {
+			break
+		}
+	}
+	if err := s.saveActiveHandshake(err); err != nil {
+		return false, err
+	}
+	return s.activeHandshake.AllPeersReceived(), nil
 }
 
 // set is a wrapper for combining the cipher and storage interfaces. Data in the value component is encrypted and then
@@ -162,13 +461,19 @@ func (s *Session) set(key string, value []byte) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	return s.storage.Set(key, encrypted)
+	if err := s.indexPath(key); err != nil {
+		return "", err
+	}
+	if _, err := s.storage.Set(s.storageKey(key), encrypted); err != nil {
+		return "", err
+	}
+	return key, nil
 }
 
 // get is a wrapper for combining the cipher and storage interfaces. Retrieved data is decrypted and returned
 // unencrypted as a byte slice and error
 func (s *Session) get(key string) ([]byte, error) {
-	encrypted, err := s.storage.Get(key)
+	encrypted, err := s.storage.Get(s.storageKey(key))
 	if err != nil {
 		return []byte{}, err
 	}
@@ -176,8 +481,15 @@ func (s *Session) get(key string) ([]byte, error) {
 }
 
 // NewChat creates a new chat from the activeHandshake and returns a chat ID string and error.
-// If the chat is successfully created, it deletes the contents of the activeHandshake
+// If the chat is successfully created, it wipes the activeHandshake and its stored state. If the stored
+// state can't be wiped, the chat ID is returned along with the error.
 func (s *Session) NewChat() (string, error) {
+	if err := s.active(); err != nil {
+		return "", err
+	}
+	if err := s.pendingHandshake(); err != nil {
+		return "", err
+	}
 	peerTotal := s.GetHandshakePeerTotal()
 	negotiatorCount := len(s.activeHandshake.Negotiators)
 	if peerTotal < 2 {
@@ -186,20 +498,32 @@ func (s *Session) NewChat() (string, error) {
 	if peerTotal != negotiatorCount {
 		return "", fmt.Errorf("expected peer total to be %v but counted %v", peerTotal, negotiatorCount)
 	}
-	chatID := hex.EncodeToString(genRandBytes(chatIDLength))
 	negotiators, err := s.activeHandshake.SortedNegotiatorList()
 	if err != nil {
 		return "", err
 	}
 	pepper := generatePepper(negotiators)
+	defer zeroBytes(pepper)
+	chatID, err := deriveID(pepper, "chat")
+	if err != nil {
+		return "", err
+	}
 	config := chat{
 		ID:    chatID,
 		Peers: make(map[string]chatPeer),
+		// every peer pads with the scheme shared by the initiator, which is first in sort order
+		Settings: chatSettings{
+			Padding: negotiators[0].Strategy.Padding,
+		},
 	}
 	basePath := fmt.Sprintf("chats/%v/%v", chatID, s.profile.ID)
 	for _, n := range negotiators {
+		peerID, err := deriveID(pepper, fmt.Sprintf("peer/%v", n.SortOrder))
+		if err != nil {
+			return "", err
+		}
 		cp := chatPeer{
-			ID:       hex.EncodeToString(genRandBytes(chatIDLength)),
+			ID:       peerID,
 			Alias:    n.Alias,
 			Strategy: n.Strategy,
 		}
@@ -211,38 +535,73 @@ func (s *Session) NewChat() (string, error) {
 		var e [96]byte
 		copy(p[:], pepper)
 		copy(e[:], n.Entropy)
-		// TODO support cipherType inspection
-		lookups, err := genLookups(p, e, SecretBox, defaultLookupCount)
+		cipherSettings, err := n.Strategy.Cipher.export()
+		if err != nil {
+			s.deleteAllWithPrefix(basePath)
+			return "", err
+		}
+		lookups, err := genLookups(p, e, cipherSettings.Type, n.Strategy.LookupKDF, defaultLookupCount)
+		zeroBytes(p[:])
+		zeroBytes(e[:])
 		if err != nil {
+			s.deleteAllWithPrefix(basePath)
 			return "", err
 		}
-		if err := s.setLookup(chatID, cp.ID, lookups); err != nil {
-			deleteAllWithPrefix(s.storage, basePath)
+		err = s.setLookup(chatID, cp.ID, lookups)
+		lookups.wipe()
+		if err != nil {
+			s.deleteAllWithPrefix(basePath)
 			return "", err
 		}
 	}
 	if config.PeerID == "" {
-		deleteAllWithPrefix(s.storage, basePath)
+		s.deleteAllWithPrefix(basePath)
 		return "", errors.New("primary PeerID not found for chat")
 	}
 
 	if err := s.setChat(chatID, config); err != nil {
-		deleteAllWithPrefix(s.storage, basePath)
+		s.deleteAllWithPrefix(basePath)
 		return "", err
 	}
 
 	if err := s.setChatLog(chatID, make(ChatLog)); err != nil {
-		deleteAllWithPrefix(s.storage, basePath)
+		s.deleteAllWithPrefix(basePath)
 		return "", err
 	}
 
-	s.activeHandshake = &handshake{}
-	return chatID, nil
+	return chatID, s.cancelHandshake(s.activeHandshake.ID)
+}
+
+// SetHandshakePadding takes a PaddingScheme and sets it as the padding shared by the initiator of the active
+// handshake. Every peer of the chat created from the handshake pads messages with it. It must be set before any
+// peer is added.
+func (s *Session) SetHandshakePadding(scheme PaddingScheme) error {
+	if err := s.active(); err != nil {
+		return err
+	}
+	if err := s.pendingHandshake(); err != nil {
+		return err
+	}
+	if s.activeHandshake.Role != initiator {
+		return errors.New("only the initiator can set the handshake padding")
+	}
+	if len(s.activeHandshake.Negotiators) > 1 {
+		return errors.New("handshake padding must be set before peers are added")
+	}
+	if _, err := pad(scheme, []byte{}); err != nil {
+		return err
+	}
+	s.activeHandshake.Position.Strategy.Padding = scheme
+	s.activeHandshake.Negotiators[0].Strategy.Padding = scheme
+	return s.saveActiveHandshake(nil)
 }
 
 // ListChats returns a json encoded list of chatIDs and an error
 func (s *Session) ListChats() ([]byte, error) {
-	list, err := s.storage.List("chats/")
+	if err := s.active(); err != nil {
+		return []byte{}, err
+	}
+	list, err := s.list("chats/")
 	if err != nil {
 		return []byte{}, err
 	}
@@ -272,12 +631,14 @@ func (s *Session) setChat(chatID string, c chat) error {
 	return err
 }
 
+// getLookup returns the lookup for a chat peer and an error. Callers should wipe the lookup once done with it.
 func (s *Session) getLookup(chatID, peerID string) (lookup, error) {
 	key := fmt.Sprintf("chats/%v/%v/lookups/%v", chatID, s.profile.ID, peerID)
 	lookupGob, err := s.get(key)
 	if err != nil {
 		return lookup{}, err
 	}
+	defer zeroBytes(lookupGob)
 	return newLookupFromGob(lookupGob)
 }
 
@@ -287,12 +648,20 @@ func (s *Session) setLookup(chatID, peerID string, l lookup) error {
 	if err != nil {
 		return err
 	}
+	defer zeroBytes(lookupGob)
 	_, err = s.set(key, lookupGob)
 	return err
 }
 
 // GetChatLog fetches a chat log for a given chat
 func (s *Session) GetChatLog(chatID string) (ChatLog, error) {
+	if err := s.active(); err != nil {
+		return ChatLog{}, err
+	}
+	return s.getChatLog(chatID)
+}
+
+func (s *Session) getChatLog(chatID string) (ChatLog, error) {
 	key := fmt.Sprintf("chats/%v/%v/chatlog", chatID, s.profile.ID)
 	chatLogGob, err := s.get(key)
 	if err != nil {
@@ -320,6 +689,7 @@ func (s *Session) getRendezvousHash(chatID, peerID string) (hash string) {
 	if err != nil {
 		return
 	}
+	defer l.wipe()
 
 	rBytes, err := c.Peers[peerID].Strategy.Rendezvous.Get("")
 	if err != nil {
@@ -328,6 +698,7 @@ func (s *Session) getRendezvousHash(chatID, peerID string) (hash string) {
 
 	rHash := base64.StdEncoding.EncodeToString(rBytes[:lookupHashLength])
 	rKey := l.popKey(rHash)
+	defer zeroBytes(rKey)
 	if err := s.setLookup(chatID, peerID, l); err != nil {
 		return
 	}
@@ -335,9 +706,9 @@ func (s *Session) getRendezvousHash(chatID, peerID string) (hash string) {
 	if err != nil {
 		return
 	}
-	hash = string(hashBytes)
+	hash = string(unpad(hashBytes))
 
-	cl, err := s.GetChatLog(chatID)
+	cl, err := s.getChatLog(chatID)
 	if err != nil {
 		return
 	}
@@ -361,6 +732,7 @@ func (s *Session) retrieveMessage(chatID, hash, peerID string) (data chatData, e
 	if err != nil {
 		return
 	}
+	defer l.wipe()
 
 	b, err := c.Peers[peerID].Strategy.Storage.Get(hash)
 	if err != nil {
@@ -371,6 +743,7 @@ func (s *Session) retrieveMessage(chatID, hash, peerID string) (data chatData, e
 	if len(key) == 0 {
 		return data, errors.New("no key")
 	}
+	defer zeroBytes(key)
 	err = s.setLookup(chatID, peerID, l)
 	if err != nil {
 		return
@@ -379,7 +752,7 @@ func (s *Session) retrieveMessage(chatID, hash, peerID string) (data chatData, e
 	if err != nil {
 		return
 	}
-	err = json.Unmarshal(d, &data)
+	err = json.Unmarshal(unpad(d), &data)
 	if err != nil {
 		return
 	}
@@ -388,7 +761,7 @@ func (s *Session) retrieveMessage(chatID, hash, peerID string) (data chatData, e
 }
 
 func (s *Session) logChatData(chatID string, peerID string, hash string, data chatData) error {
-	cl, err := s.GetChatLog(chatID)
+	cl, err := s.getChatLog(chatID)
 	if err != nil {
 		return err
 	}
@@ -411,7 +784,7 @@ func (s *Session) recursivelyLogParents(chatID string, peerID string, data chatD
 	if data.Parent == "" {
 		return nil // if no parent set, return early
 	}
-	cl, err := s.GetChatLog(chatID)
+	cl, err := s.getChatLog(chatID)
 	if err != nil {
 		return err
 	}
@@ -437,6 +810,9 @@ func (s *Session) recursivelyLogParents(chatID string, peerID string, data chatD
 // RetrieveMessages takes a chatID and initiates the retrieval process for all peers
 // it returns a json encoded chatLogList and error
 func (s *Session) RetrieveMessages(chatID string) ([]byte, error) {
+	if err := s.active(); err != nil {
+		return []byte{}, err
+	}
 	// this should query all peer endpoints and update the chatlog
 	// this step also runs ttl validation to clear out old messages
 	// it returns a json encoded chatLogList
@@ -467,7 +843,7 @@ func (s *Session) RetrieveMessages(chatID string) ([]byte, error) {
 		}
 
 	}
-	cl, err := s.GetChatLog(chatID)
+	cl, err := s.getChatLog(chatID)
 	if err != nil {
 		return []byte{}, err
 	}
@@ -476,6 +852,9 @@ func (s *Session) RetrieveMessages(chatID string) ([]byte, error) {
 
 // GetMyPeerID returns a string of the profile user's peerID for a specific chat, returns the peerID and an error
 func (s *Session) GetMyPeerID(chatID string) (string, error) {
+	if err := s.active(); err != nil {
+		return "", err
+	}
 	c, err := s.getChat(chatID)
 	if err != nil {
 		return "", err
@@ -486,6 +865,9 @@ func (s *Session) GetMyPeerID(chatID string) (string, error) {
 // SendMessage takes a chatID and message bytes and submits the message to the message
 // storage and rendezvous point. It returns a json encoded chatLogList and error
 func (s *Session) SendMessage(chatID string, b []byte) ([]byte, error) {
+	if err := s.active(); err != nil {
+		return []byte{}, err
+	}
 	if len(b) > maxMessageSize {
 		return []byte{}, fmt.Errorf("messag sized exceeds max size of %v bytes", maxMessageSize)
 	}
@@ -507,6 +889,13 @@ func (s *Session) SendMessage(chatID string, b []byte) ([]byte, error) {
 	if err != nil {
 		return []byte{}, nil
 	}
+	if len(dataBytes) > maxMessageSize {
+		return []byte{}, ErrMessageTooLarge
+	}
+	paddedData, err := pad(c.Settings.Padding, dataBytes)
+	if err != nil {
+		return []byte{}, err
+	}
 
 	sender := c.Peers[c.PeerID]
 
@@ -514,7 +903,9 @@ func (s *Session) SendMessage(chatID string, b []byte) ([]byte, error) {
 	if err != nil {
 		return []byte{}, err
 	}
+	defer l.wipe()
 	mStoreKey, mStoreValue := l.popRandom()
+	defer zeroBytes(mStoreValue)
 	if err := s.setLookup(chatID, c.PeerID, l); err != nil {
 		return []byte{}, err
 	}
@@ -524,7 +915,7 @@ func (s *Session) SendMessage(chatID string, b []byte) ([]byte, error) {
 		return []byte{}, err
 	}
 
-	cipherText, err := sender.Strategy.Cipher.Encrypt(dataBytes, mStoreValue)
+	cipherText, err := sender.Strategy.Cipher.Encrypt(paddedData, mStoreValue)
 	if err != nil {
 		return []byte{}, err
 	}
@@ -532,7 +923,10 @@ func (s *Session) SendMessage(chatID string, b []byte) ([]byte, error) {
 	var payload []byte
 	payload = append(payload, mStoreKeyBytes...)
 	payload = append(payload, cipherText...)
-	hash, err := sender.Strategy.Storage.Set("", payload)
+	if len(payload) > maxPayloadSize {
+		return []byte{}, ErrMessageTooLarge
+	}
+	hash, replicas, err := setMessage(sender.Strategy.Storage, payload)
 	if err != nil {
 		return []byte{}, err
 	}
@@ -543,6 +937,7 @@ func (s *Session) SendMessage(chatID string, b []byte) ([]byte, error) {
 	}
 
 	rStoreKey, rStoreValue := l.popRandom()
+	defer zeroBytes(rStoreValue)
 	if err := s.setLookup(chatID, c.PeerID, l); err != nil {
 		return []byte{}, err
 	}
@@ -552,7 +947,8 @@ func (s *Session) SendMessage(chatID string, b []byte) ([]byte, error) {
 		return []byte{}, err
 	}
 
-	rCipherText, err := sender.Strategy.Cipher.Encrypt([]byte(hash), rStoreValue)
+	// rendezvous payloads only carry a hash and must fit hashmap, so they are padded to a fixed length
+	rCipherText, err := sender.Strategy.Cipher.Encrypt(padTo(rendezvousPaddedLength, []byte(hash)), rStoreValue)
 	if err != nil {
 		return []byte{}, err
 	}
@@ -565,17 +961,18 @@ func (s *Session) SendMessage(chatID string, b []byte) ([]byte, error) {
 		return []byte{}, err
 	}
 
-	cl, err := s.GetChatLog(chatID)
+	cl, err := s.getChatLog(chatID)
 	if err != nil {
 		return []byte{}, err
 	}
 
 	clEntry := ChatLogEntry{
-		ID:     hash,
-		Sender: c.PeerID,
-		Sent:   data.Timestamp,
-		TTL:    data.TTL,
-		Data:   data,
+		ID:       hash,
+		Sender:   c.PeerID,
+		Sent:     data.Timestamp,
+		TTL:      data.TTL,
+		Data:     data,
+		Replicas: replicas,
 	}
 
 	if err := cl.AddEntry(clEntry); err != nil {
@@ -588,19 +985,14 @@ func (s *Session) SendMessage(chatID string, b []byte) ([]byte, error) {
 	return cl.SortedJSON()
 }
 
-// deleteAllWithPrefix takes a storage interface and a prefix string. It looks up all keys that
-// match the prefix and attempts to run the Delete method on all keys, returns a error or nil.
-func deleteAllWithPrefix(s storage.Storage, prefix string) error {
-	keys, err := s.List(prefix)
-	if err != nil {
-		return err
+// setMessage takes a storage interface and a payload and stores the payload. If the storage engine
+// supports it, the per-node outcome of the write is returned along with the hash and an error.
+func setMessage(s storage.Storage, payload []byte) (string, []storage.NodeResult, error) {
+	if rs, ok := s.(storage.ReplicatedStorage); ok {
+		return rs.SetReplicated("", payload)
 	}
-	for _, key := range keys {
-		if err := s.Delete(key); err != nil {
-			return err
-		}
-	}
-	return nil
+	hash, err := s.Set("", payload)
+	return hash, nil, err
 }
 
 // gobBytes takes an empty interface and returns a byte slice and error
@@ -1,6 +1,7 @@
 package handshake
 
 import (
+	"bytes"
 	"os"
 	"testing"
 )
@@ -29,3 +30,63 @@ func TestNewDefaultSession(t *testing.T) {
 		s2.Close()
 	}
 }
+
+func TestSessionLockAndExpiry(t *testing.T) {
+	path := "lock-handshake.boltdb"
+	os.Remove(path)
+	defer os.Remove(path)
+	password := "lock password"
+	chatID := "a1b2c3"
+
+	s := newRotationTestSession(t, path, password, chatID)
+	defer s.Close()
+	key := append([]byte{}, s.GetProfile().Key...)
+	if err := s.NewInitiatorWithDefaults(); err != nil {
+		t.Fatal(err)
+	}
+	handshakeID := s.activeHandshake.ID
+	entropy := s.activeHandshake.Position.Entropy
+
+	s.Lock()
+	if !bytes.Equal(entropy, make([]byte, len(entropy))) {
+		t.Error("handshake entropy was not wiped on Lock")
+	}
+	if _, err := s.GetChatLog(chatID); err != ErrSessionLocked {
+		t.Errorf("expected ErrSessionLocked, got %v", err)
+	}
+	if len(s.GetProfile().Key) != 0 {
+		t.Error("profile key was not cleared on Lock")
+	}
+	if err := s.Unlock("junk password"); err == nil {
+		t.Error("Unlock returned no error with bad password")
+	}
+	if err := s.Unlock(password); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key, s.GetProfile().Key) {
+		t.Error("profile key was not restored on Unlock")
+	}
+	if err := s.ResumeHandshake(handshakeID); err != nil {
+		t.Errorf("handshake was not resumed after Unlock: %v", err)
+	}
+	if _, err := s.GetChatLog(chatID); err != nil {
+		t.Error(err)
+	}
+
+	s.startTime -= s.ttl
+	if _, err := s.GetChatLog(chatID); err != ErrSessionExpired {
+		t.Errorf("expected ErrSessionExpired, got %v", err)
+	}
+	if err := s.NewInitiatorWithDefaults(); err != ErrSessionExpired {
+		t.Errorf("expected ErrSessionExpired, got %v", err)
+	}
+	if err := s.Unlock(password); err != nil {
+		t.Fatal(err)
+	}
+
+	s.idleTimeout = 60
+	s.lastActive -= 60
+	if _, err := s.SendMessage(chatID, []byte(`{"message":"hello"}`)); err != ErrSessionExpired {
+		t.Errorf("expected ErrSessionExpired, got %v", err)
+	}
+}
@@ -0,0 +1,410 @@
+package handshake
+
+import (
+	"bytes"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/nomasters/handshake/lib/storage"
+	"github.com/nomasters/handshake/lib/tlv"
+	"golang.org/x/crypto/blake2b"
+)
+
+const (
+	// shareVersion is the first byte of a binary share and is bumped if the encoding changes
+	shareVersion byte = 1
+	// shareTextPrefix starts the text form of a share
+	shareTextPrefix = "HSC1"
+	// shareTextGroupSize is the number of characters between the dashes of the text form of a share
+	shareTextGroupSize = 5
+)
+
+// share kinds, stored after the version byte of a binary share
+const (
+	peerConfigShare byte = iota + 1
+	peerConfigListShare
+	pinWrappedShare
+)
+
+// tags of the tlv fields of a binary share
+const (
+	shareTagEntropy byte = iota + 1
+	shareTagAlias
+	shareTagItem
+	shareTagTotalItems
+	shareTagRendezvous
+	shareTagStorage
+	shareTagCipherType
+	shareTagChunkSize
+	shareTagKDFVersion
+	shareTagKDFTime
+	shareTagKDFMemory
+	shareTagKDFThreads
+	shareTagPeer
+	shareTagPadding
+)
+
+// shareFieldNames maps the tags of a binary share to the json names of the fields they hold
+var shareFieldNames = map[byte]string{
+	shareTagEntropy:    "entropy",
+	shareTagAlias:      "alias",
+	shareTagItem:       "item",
+	shareTagTotalItems: "total_items",
+	shareTagRendezvous: "config.rendezvous",
+	shareTagStorage:    "config.storage",
+	shareTagCipherType: "config.cipher.type",
+	shareTagChunkSize:  "config.cipher.chunk_size",
+	shareTagKDFVersion: "config.lookup_kdf.version",
+	shareTagKDFTime:    "config.lookup_kdf.time",
+	shareTagKDFMemory:  "config.lookup_kdf.memory",
+	shareTagKDFThreads: "config.lookup_kdf.threads",
+	shareTagPeer:       "peers",
+	shareTagPadding:    "config.padding",
+}
+
+// shareTextEncoding is used for the text form of a share. It is case insensitive when decoding.
+var shareTextEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// ShareError is returned by AddPeerToHandshake and AddPeersToHandshake when a share is corrupt or can't be decoded
+type ShareError struct {
+	// Field is the name of the field that failed to decode, if known
+	Field string
+	// Offset is the byte offset in the binary or json share at which decoding failed, or -1 if unknown
+	Offset int
+	// Reason describes why decoding failed
+	Reason string
+}
+
+// Error returns the error string for a ShareError
+func (e ShareError) Error() string {
+	msg := "invalid share"
+	if e.Field != "" {
+		msg += fmt.Sprintf(": %v", e.Field)
+	}
+	msg += fmt.Sprintf(": %v", e.Reason)
+	if e.Offset >= 0 {
+		msg += fmt.Sprintf(" at byte %v", e.Offset)
+	}
+	return msg
+}
+
+// newShareError takes a field name and an error returned while decoding it and returns a ShareError
+func newShareError(field string, err error) ShareError {
+	switch e := err.(type) {
+	case ShareError:
+		return e
+	case tlv.Error:
+		return ShareError{Field: field, Offset: e.Offset, Reason: e.Reason}
+	case *json.SyntaxError:
+		return ShareError{Field: field, Offset: int(e.Offset), Reason: e.Error()}
+	case *json.UnmarshalTypeError:
+		return ShareError{Field: e.Field, Offset: int(e.Offset), Reason: fmt.Sprintf("cannot decode %v into %v", e.Value, e.Type)}
+	default:
+		return ShareError{Field: field, Offset: -1, Reason: err.Error()}
+	}
+}
+
+// EncodeShareText takes a binary share and returns its text form: HSC1 followed by the base32 encoded share, in
+// dash separated groups that are easy to read aloud and type
+func EncodeShareText(share []byte) string {
+	encoded := shareTextEncoding.EncodeToString(share)
+	groups := []string{shareTextPrefix}
+	for len(encoded) > shareTextGroupSize {
+		groups = append(groups, encoded[:shareTextGroupSize])
+		encoded = encoded[shareTextGroupSize:]
+	}
+	return strings.Join(append(groups, encoded), "-")
+}
+
+// IsShareText returns true if s looks like the text form of a share
+func IsShareText(s string) bool {
+	return strings.HasPrefix(strings.ToUpper(strings.TrimSpace(s)), shareTextPrefix)
+}
+
+// DecodeShareText takes the text form of a share and returns the binary share and an error. Case, whitespace
+// and dashes are ignored. A ShareError is returned if the text is mistyped or fails its checksum.
+func DecodeShareText(text string) ([]byte, error) {
+	text = strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' || r == '\t' || r == '\r' || r == '\n' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(text))
+	if !strings.HasPrefix(text, shareTextPrefix) {
+		return []byte{}, ShareError{Offset: -1, Reason: "missing " + shareTextPrefix + " prefix"}
+	}
+	text = strings.TrimPrefix(text, shareTextPrefix)
+	b, err := shareTextEncoding.DecodeString(text)
+	if err != nil {
+		if e, ok := err.(base32.CorruptInputError); ok {
+			reason := "text is truncated"
+			if int(e) < len(text) {
+				reason = fmt.Sprintf("invalid character %q after %v characters", text[e], int(e))
+			}
+			return []byte{}, ShareError{Offset: -1, Reason: reason}
+		}
+		return []byte{}, ShareError{Offset: -1, Reason: err.Error()}
+	}
+	if err := verifyShareChecksum(b); err != nil {
+		return []byte{}, ShareError{Offset: -1, Reason: "checksum mismatch, the text was mistyped or is incomplete"}
+	}
+	return b, nil
+}
+
+// verifyShareChecksum returns a ShareError if a binary share is too short or doesn't end with its checksum
+func verifyShareChecksum(b []byte) error {
+	if len(b) < 2+shareChecksumLength {
+		return ShareError{Field: "version", Offset: len(b), Reason: "share is truncated"}
+	}
+	end := len(b) - shareChecksumLength
+	sum := blake2b.Sum256(b[:end])
+	if !bytes.Equal(b[end:], sum[:shareChecksumLength]) {
+		return ShareError{Field: "checksum", Offset: end, Reason: "checksum mismatch"}
+	}
+	return nil
+}
+
+// isJSONShare returns true if the share is json encoded rather than binary or text
+func isJSONShare(body []byte) bool {
+	b := bytes.TrimSpace(body)
+	return len(b) > 0 && (b[0] == '{' || b[0] == '[')
+}
+
+// encodeShare takes a share kind and tlv encoded fields and returns a binary share: the version, the kind, the
+// fields, and the first bytes of the blake2b-256 hash of what precedes them as a checksum
+func encodeShare(kind byte, fields []byte) []byte {
+	b := append([]byte{shareVersion, kind}, fields...)
+	sum := blake2b.Sum256(b)
+	return append(b, sum[:shareChecksumLength]...)
+}
+
+// decodeShare takes a binary share, or the text form of a share, and the expected kind and returns the tlv
+// fields of the share and an error
+func decodeShare(body []byte, kind byte) ([]tlv.Field, error) {
+	b := body
+	if IsShareText(string(body)) {
+		var err error
+		if b, err = DecodeShareText(string(body)); err != nil {
+			return nil, err
+		}
+	}
+	if len(b) > 0 && b[0] != shareVersion {
+		return nil, ShareError{Field: "version", Offset: 0, Reason: fmt.Sprintf("unsupported share version %v", b[0])}
+	}
+	if err := verifyShareChecksum(b); err != nil {
+		return nil, err
+	}
+	if b[1] != kind {
+		return nil, ShareError{Field: "kind", Offset: 1, Reason: fmt.Sprintf("expected share kind %v, got %v", kind, b[1])}
+	}
+	fields, err := tlv.Decode(b[2:len(b)-shareChecksumLength], 2)
+	if err != nil {
+		return nil, newShareError("", err)
+	}
+	return fields, nil
+}
+
+// encodeBinary returns the peerConfig as tlv encoded fields and an error
+func (c peerConfig) encodeBinary() ([]byte, error) {
+	entropy, err := base64.StdEncoding.DecodeString(c.Entropy)
+	if err != nil {
+		return []byte{}, err
+	}
+	var e tlv.Encoder
+	e.Bytes(shareTagEntropy, entropy)
+	if c.Alias != "" {
+		e.String(shareTagAlias, c.Alias)
+	}
+	if c.Item != 0 {
+		e.Uint(shareTagItem, uint64(c.Item))
+	}
+	if c.TotalItems != 0 {
+		e.Uint(shareTagTotalItems, uint64(c.TotalItems))
+	}
+	e.Bytes(shareTagRendezvous, c.Config.Rendezvous.EncodeBinary())
+	e.Bytes(shareTagStorage, c.Config.Storage.EncodeBinary())
+	if c.Config.Cipher.Type != 0 {
+		e.Uint(shareTagCipherType, uint64(c.Config.Cipher.Type))
+	}
+	if c.Config.Cipher.ChunkSize != 0 {
+		e.Uint(shareTagChunkSize, uint64(c.Config.Cipher.ChunkSize))
+	}
+	if kdf := c.Config.LookupKDF; kdf != nil {
+		e.Uint(shareTagKDFVersion, uint64(kdf.Version))
+		e.Uint(shareTagKDFTime, uint64(kdf.Time))
+		e.Uint(shareTagKDFMemory, uint64(kdf.Memory))
+		e.Uint(shareTagKDFThreads, uint64(kdf.Threads))
+	}
+	if c.Config.Padding != nil {
+		e.Uint(shareTagPadding, uint64(*c.Config.Padding))
+	}
+	return e.Encoded(), nil
+}
+
+// decodePeerConfigFields takes the tlv fields of a binary encoded peerConfig and returns the peerConfig and an error
+func decodePeerConfigFields(fields []tlv.Field) (peerConfig, error) {
+	var c peerConfig
+	seen := make(map[byte]bool)
+	for _, f := range fields {
+		name := shareFieldNames[f.Tag]
+		if name == "" || f.Tag == shareTagPeer {
+			return c, ShareError{Offset: f.Offset, Reason: fmt.Sprintf("unknown tag %v", f.Tag)}
+		}
+		if seen[f.Tag] {
+			return c, ShareError{Field: name, Offset: f.Offset, Reason: "duplicate field"}
+		}
+		seen[f.Tag] = true
+		var err error
+		switch f.Tag {
+		case shareTagEntropy:
+			c.Entropy = base64.StdEncoding.EncodeToString(f.Value)
+		case shareTagAlias:
+			c.Alias = string(f.Value)
+		case shareTagRendezvous:
+			c.Config.Rendezvous, err = storage.DecodePeerStorage(f.Value, f.Offset)
+		case shareTagStorage:
+			c.Config.Storage, err = storage.DecodePeerStorage(f.Value, f.Offset)
+		default:
+			err = decodeShareUint(&c, f)
+		}
+		if err != nil {
+			return c, newShareError(name, err)
+		}
+	}
+	for _, tag := range []byte{shareTagEntropy, shareTagRendezvous, shareTagStorage} {
+		if !seen[tag] {
+			return c, ShareError{Field: shareFieldNames[tag], Offset: -1, Reason: "missing field"}
+		}
+	}
+	return c, nil
+}
+
+// decodeShareUint takes a peerConfig and a tlv field holding an integer and sets the peerConfig field it is tagged with
+func decodeShareUint(c *peerConfig, f tlv.Field) error {
+	v, err := f.Uint()
+	if err != nil {
+		return err
+	}
+	max := uint64(math.MaxInt32)
+	switch f.Tag {
+	case shareTagKDFTime, shareTagKDFMemory:
+		max = math.MaxUint32
+	case shareTagKDFVersion, shareTagKDFThreads:
+		max = math.MaxUint8
+	}
+	if v > max {
+		return tlv.Error{Offset: f.Offset, Reason: fmt.Sprintf("value %v is out of range", v)}
+	}
+	if f.Tag >= shareTagKDFVersion && f.Tag <= shareTagKDFThreads && c.Config.LookupKDF == nil {
+		c.Config.LookupKDF = &KDFParams{}
+	}
+	switch f.Tag {
+	case shareTagItem:
+		c.Item = int(v)
+	case shareTagTotalItems:
+		c.TotalItems = int(v)
+	case shareTagCipherType:
+		c.Config.Cipher.Type = CipherType(v)
+	case shareTagChunkSize:
+		c.Config.Cipher.ChunkSize = int(v)
+	case shareTagKDFVersion:
+		c.Config.LookupKDF.Version = KDFVersion(v)
+	case shareTagKDFTime:
+		c.Config.LookupKDF.Time = uint32(v)
+	case shareTagKDFMemory:
+		c.Config.LookupKDF.Memory = uint32(v)
+	case shareTagKDFThreads:
+		c.Config.LookupKDF.Threads = uint8(v)
+	case shareTagPadding:
+		padding := PaddingScheme(v)
+		c.Config.Padding = &padding
+	}
+	return nil
+}
+
+// encodePeerConfigShare takes a peerConfig and returns it as a binary share and an error
+func encodePeerConfigShare(c peerConfig) ([]byte, error) {
+	fields, err := c.encodeBinary()
+	if err != nil {
+		return []byte{}, err
+	}
+	return encodeShare(peerConfigShare, fields), nil
+}
+
+// encodePeerConfigListShare takes a list of peerConfigs and returns it as a binary share and an error
+func encodePeerConfigListShare(configs []peerConfig) ([]byte, error) {
+	var e tlv.Encoder
+	for _, c := range configs {
+		fields, err := c.encodeBinary()
+		if err != nil {
+			return []byte{}, err
+		}
+		e.Bytes(shareTagPeer, fields)
+	}
+	return encodeShare(peerConfigListShare, e.Encoded()), nil
+}
+
+// decodePeerConfigShare takes a peerConfig encoded as json, as a binary share, or as the text form of a share
+// and returns the peerConfig and an error. Decoding errors are ShareError values.
+func decodePeerConfigShare(body []byte) (peerConfig, error) {
+	var c peerConfig
+	if isJSONShare(body) {
+		if err := json.Unmarshal(body, &c); err != nil {
+			return c, newShareError("", err)
+		}
+		return c, validateShareEntropy(c)
+	}
+	fields, err := decodeShare(body, peerConfigShare)
+	if err != nil {
+		return c, err
+	}
+	return decodePeerConfigFields(fields)
+}
+
+// decodePeerConfigListShare takes a list of peerConfigs encoded as json, as a binary share, or as the text form
+// of a share and returns the peerConfigs and an error. Decoding errors are ShareError values.
+func decodePeerConfigListShare(body []byte) ([]peerConfig, error) {
+	var configs []peerConfig
+	if isJSONShare(body) {
+		if err := json.Unmarshal(body, &configs); err != nil {
+			return configs, newShareError("", err)
+		}
+		for _, c := range configs {
+			if err := validateShareEntropy(c); err != nil {
+				return configs, err
+			}
+		}
+		return configs, nil
+	}
+	fields, err := decodeShare(body, peerConfigListShare)
+	if err != nil {
+		return configs, err
+	}
+	for _, f := range fields {
+		if f.Tag != shareTagPeer {
+			return configs, ShareError{Offset: f.Offset, Reason: fmt.Sprintf("unknown tag %v", f.Tag)}
+		}
+		peerFields, err := f.Fields()
+		if err != nil {
+			return configs, newShareError("peers", err)
+		}
+		c, err := decodePeerConfigFields(peerFields)
+		if err != nil {
+			return configs, err
+		}
+		configs = append(configs, c)
+	}
+	return configs, nil
+}
+
+// validateShareEntropy returns a ShareError if the entropy of a json encoded peerConfig isn't valid base64
+func validateShareEntropy(c peerConfig) error {
+	if _, err := base64.StdEncoding.DecodeString(c.Entropy); err != nil {
+		return ShareError{Field: "entropy", Offset: -1, Reason: err.Error()}
+	}
+	return nil
+}
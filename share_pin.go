@@ -0,0 +1,216 @@
+package handshake
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/nomasters/handshake/lib/tlv"
+)
+
+const (
+	// DefaultPINLength is the number of digits in a generated handshake PIN. A captured share can be guessed
+	// offline at the cost of one DefaultKDFParams derivation per guess, so the PIN has to be long enough that
+	// searching its 10^12 values is impractical.
+	DefaultPINLength = 12
+	// pinSaltLength is the length in bytes of the salt used to derive the key of a PIN wrapped share
+	pinSaltLength = 16
+	// pinKDFLength is the length in bytes of the KDFParams stored in a PIN wrapped share
+	pinKDFLength = 10
+)
+
+// tags of the tlv fields of a PIN wrapped share
+const (
+	pinTagKDF byte = iota + 1
+	pinTagSalt
+	pinTagSealed
+)
+
+var (
+	// ErrSharePINRequired is returned when a PIN wrapped share is added to a handshake without a PIN
+	ErrSharePINRequired = errors.New("share is wrapped with a PIN, set the handshake PIN to add it")
+	// ErrInvalidSharePIN is returned when a PIN wrapped share fails to open with the handshake PIN
+	ErrInvalidSharePIN = errors.New("invalid PIN for share")
+	// ErrShareNotWrapped is returned when a share that is not PIN wrapped is added to a handshake with a PIN
+	ErrShareNotWrapped = errors.New("share is not wrapped with the handshake PIN")
+)
+
+// generatePIN takes a length and returns a random PIN of that many decimal digits
+func generatePIN(length int) ([]byte, error) {
+	pin := make([]byte, length)
+	for i := range pin {
+		n, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			return []byte{}, err
+		}
+		pin[i] = byte('0' + n.Int64())
+	}
+	return pin, nil
+}
+
+// normalizePIN takes a PIN as typed and returns it without whitespace and dashes
+func normalizePIN(pin string) []byte {
+	return []byte(strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' || r == '\t' || r == '\r' || r == '\n' {
+			return -1
+		}
+		return r
+	}, pin))
+}
+
+// wrapShare takes a binary share, a PIN, and KDFParams and returns a PIN wrapped share, sealed with secretbox
+// under a key derived from the PIN and a random salt, and an error. The PIN only protects a share that is
+// captured, such as a photographed QR code, for as long as guessing it offline takes, so it hides a share
+// during the handshake but is no substitute for exchanging shares privately.
+func wrapShare(share, pin []byte, params KDFParams) ([]byte, error) {
+	if len(pin) == 0 {
+		return []byte{}, errors.New("PIN is empty")
+	}
+	salt := genRandBytes(pinSaltLength)
+	key, err := params.key(pin, salt, secretBoxKeyLength)
+	if err != nil {
+		return []byte{}, err
+	}
+	defer zeroBytes(key)
+	sealed, err := newDefaultSBCipher().Encrypt(share, key)
+	if err != nil {
+		return []byte{}, err
+	}
+	kdf := make([]byte, pinKDFLength)
+	kdf[0] = byte(params.Version)
+	binary.BigEndian.PutUint32(kdf[1:5], params.Time)
+	binary.BigEndian.PutUint32(kdf[5:9], params.Memory)
+	kdf[9] = params.Threads
+
+	var e tlv.Encoder
+	e.Bytes(pinTagKDF, kdf)
+	e.Bytes(pinTagSalt, salt)
+	e.Bytes(pinTagSealed, sealed)
+	return encodeShare(pinWrappedShare, e.Encoded()), nil
+}
+
+// unwrapShare takes a PIN wrapped share, binary or in text form, and a PIN and returns the binary share and an
+// error. ErrInvalidSharePIN is returned if the PIN is wrong, and a ShareError if the share is corrupt. The kdf
+// params are set by the sender, so params costlier than DefaultKDFParams are rejected rather than run.
+func unwrapShare(body, pin []byte) ([]byte, error) {
+	fields, err := decodeShare(body, pinWrappedShare)
+	if err != nil {
+		return []byte{}, err
+	}
+	var params KDFParams
+	var salt, sealed []byte
+	for _, f := range fields {
+		switch f.Tag {
+		case pinTagKDF:
+			if len(f.Value) != pinKDFLength {
+				return []byte{}, ShareError{Field: "kdf", Offset: f.Offset, Reason: "invalid kdf params"}
+			}
+			params = KDFParams{
+				Version: KDFVersion(f.Value[0]),
+				Time:    binary.BigEndian.Uint32(f.Value[1:5]),
+				Memory:  binary.BigEndian.Uint32(f.Value[5:9]),
+				Threads: f.Value[9],
+			}
+			if err := params.Validate(); err != nil {
+				return []byte{}, ShareError{Field: "kdf", Offset: f.Offset, Reason: err.Error()}
+			}
+			if params.Time > DefaultKDFParams.Time || params.Memory > DefaultKDFParams.Memory ||
+				params.Threads > DefaultKDFParams.Threads {
+				return []byte{}, ShareError{Field: "kdf", Offset: f.Offset, Reason: "kdf params exceed the defaults"}
+			}
+		case pinTagSalt:
+			salt = f.Value
+		case pinTagSealed:
+			sealed = f.Value
+		default:
+			return []byte{}, ShareError{Offset: f.Offset, Reason: fmt.Sprintf("unknown tag %v", f.Tag)}
+		}
+	}
+	if params.Version == LegacyKDF || len(salt) != pinSaltLength {
+		return []byte{}, ShareError{Field: "kdf", Offset: -1, Reason: "missing kdf params or salt"}
+	}
+	if len(sealed) <= secretBoxDecryptionOffset {
+		return []byte{}, ShareError{Field: "sealed", Offset: -1, Reason: "sealed share is truncated"}
+	}
+	key, err := params.key(pin, salt, secretBoxKeyLength)
+	if err != nil {
+		return []byte{}, err
+	}
+	defer zeroBytes(key)
+	share, err := newDefaultSBCipher().Decrypt(sealed, key)
+	if err != nil {
+		return []byte{}, ErrInvalidSharePIN
+	}
+	return share, nil
+}
+
+// isWrappedShare returns true if the share, binary or in text form, is PIN wrapped
+func isWrappedShare(body []byte) bool {
+	if IsShareText(string(body)) {
+		b, err := DecodeShareText(string(body))
+		return err == nil && len(b) > 1 && b[1] == pinWrappedShare
+	}
+	return len(body) > 1 && body[0] == shareVersion && body[1] == pinWrappedShare
+}
+
+// wrapShare takes a binary share and wraps it with the PIN of the activeHandshake, if it has one
+func (s *Session) wrapShare(share []byte) ([]byte, error) {
+	if len(s.activeHandshake.PIN) == 0 {
+		return share, nil
+	}
+	defer zeroBytes(share)
+	return wrapShare(share, s.activeHandshake.PIN, DefaultKDFParams)
+}
+
+// unwrapShare takes a share and, if it is PIN wrapped, returns it unwrapped with the PIN of the activeHandshake.
+// Once the activeHandshake has a PIN, shares that are not wrapped are rejected, so the PIN can't be bypassed.
+func (s *Session) unwrapShare(body []byte) ([]byte, error) {
+	if !isWrappedShare(body) {
+		if len(s.activeHandshake.PIN) > 0 {
+			return []byte{}, ErrShareNotWrapped
+		}
+		return body, nil
+	}
+	if len(s.activeHandshake.PIN) == 0 {
+		return []byte{}, ErrSharePINRequired
+	}
+	return unwrapShare(body, s.activeHandshake.PIN)
+}
+
+// NewHandshakePIN generates a PIN for the active handshake and returns it and an error. From then on, the shares
+// returned for the handshake are wrapped with a key derived from the PIN, and shares added to it may be. The PIN
+// is meant to be read aloud to each peer, separately from the shares, and set by them with SetHandshakePIN.
+func (s *Session) NewHandshakePIN() (string, error) {
+	if err := s.active(); err != nil {
+		return "", err
+	}
+	if err := s.pendingHandshake(); err != nil {
+		return "", err
+	}
+	pin, err := generatePIN(DefaultPINLength)
+	if err != nil {
+		return "", err
+	}
+	s.activeHandshake.PIN = pin
+	return string(pin), s.saveActiveHandshake(nil)
+}
+
+// SetHandshakePIN takes the PIN generated by the initiator with NewHandshakePIN and sets it for the active
+// handshake, so that PIN wrapped shares can be added to it and the shares it returns are wrapped
+func (s *Session) SetHandshakePIN(pin string) error {
+	if err := s.active(); err != nil {
+		return err
+	}
+	if err := s.pendingHandshake(); err != nil {
+		return err
+	}
+	p := normalizePIN(pin)
+	if len(p) == 0 {
+		return errors.New("PIN is empty")
+	}
+	s.activeHandshake.PIN = p
+	return s.saveActiveHandshake(nil)
+}
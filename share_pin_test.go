@@ -0,0 +1,110 @@
+package handshake
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestPINWrappedShare(t *testing.T) {
+	params := KDFParams{Version: Argon2idKDF, Time: 1, Memory: 8 * 1024, Threads: 1}
+	share := encodeShare(peerConfigShare, []byte("share"))
+	wrapped, err := wrapShare(share, []byte("12345678"), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(wrapped, []byte("share")) {
+		t.Error("wrapped share contains the share in plaintext")
+	}
+	text := []byte(EncodeShareText(wrapped))
+	if !isWrappedShare(wrapped) || !isWrappedShare(text) || isWrappedShare(share) {
+		t.Error("isWrappedShare does not match")
+	}
+	if b, err := unwrapShare(text, normalizePIN("1234-5678")); err != nil || !bytes.Equal(b, share) {
+		t.Errorf("expected the share to unwrap: %v", err)
+	}
+	if _, err := unwrapShare(wrapped, []byte("12345679")); err != ErrInvalidSharePIN {
+		t.Errorf("expected ErrInvalidSharePIN, got %v", err)
+	}
+	if _, err := unwrapShare(encodeShare(pinWrappedShare, nil), []byte("12345678")); err == nil {
+		t.Error("expected an error for a wrapped share without a salt")
+	}
+	costly := DefaultKDFParams
+	costly.Memory *= 2
+	if wrapped, err = wrapShare(share, []byte("12345678"), costly); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := unwrapShare(wrapped, []byte("12345678")); err == nil {
+		t.Error("expected kdf params above the defaults to be rejected")
+	}
+}
+
+func TestHandshakePIN(t *testing.T) {
+	initiatorPath := "pin-initiator-handshake.boltdb"
+	peerPath := "pin-peer-handshake.boltdb"
+	for _, path := range []string{initiatorPath, peerPath} {
+		os.Remove(path)
+		defer os.Remove(path)
+	}
+	a := newGroupTestSession(t, initiatorPath, initiator, "http://localhost", "http://localhost")
+	defer a.Close()
+	b := newGroupTestSession(t, peerPath, peer, "http://localhost", "http://localhost")
+	defer b.Close()
+
+	if err := b.SetHandshakePIN("0000-0000"); err != nil {
+		t.Fatal(err)
+	}
+	share, err := b.ShareHandshakePosition()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.AddPeerToHandshake(share); err != ErrSharePINRequired {
+		t.Errorf("expected ErrSharePINRequired, got %v", err)
+	}
+	pin, err := a.NewHandshakePIN()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pin) != DefaultPINLength {
+		t.Errorf("expected a %v digit PIN, got %v", DefaultPINLength, pin)
+	}
+	if _, err := a.AddPeerToHandshake(share); err != ErrInvalidSharePIN && pin != "00000000" {
+		t.Errorf("expected ErrInvalidSharePIN, got %v", err)
+	}
+	unwrapped, err := encodePeerConfigShare(peerConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.AddPeerToHandshake(unwrapped); err != ErrShareNotWrapped {
+		t.Errorf("expected ErrShareNotWrapped, got %v", err)
+	}
+
+	if err := b.SetHandshakePIN(pin[:4] + "-" + pin[4:]); err != nil {
+		t.Fatal(err)
+	}
+	if share, err = b.ShareHandshakePosition(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.AddPeerToHandshake([]byte(EncodeShareText(share))); err != nil {
+		t.Fatal(err)
+	}
+	configs, err := a.GetAllHandshakePeerConfigs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isWrappedShare(configs) {
+		t.Error("expected the peer configs to be wrapped with the PIN")
+	}
+	if received, err := b.AddPeersToHandshake(configs); err != nil || !received {
+		t.Errorf("expected all peers to be received: %v", err)
+	}
+
+	// the PIN is persisted with the handshake, so that it can be resumed
+	h, err := a.getHandshake(a.activeHandshake.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(h.PIN) != pin {
+		t.Error("expected the PIN to be stored with the handshake")
+	}
+}
@@ -0,0 +1,93 @@
+package handshake
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestShareEncoding(t *testing.T) {
+	h := newHandshakeInitiatorWithDefaults()
+	config, err := h.Position.PeerConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Item, config.TotalItems = 1, 2
+	if config.Config.Padding == nil || *config.Config.Padding != DefaultPaddingScheme {
+		t.Fatal("expected the peerConfig to share the padding scheme")
+	}
+	jsonShare, err := h.Position.Share()
+	if err != nil {
+		t.Fatal(err)
+	}
+	share, err := encodePeerConfigShare(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(share) >= len(jsonShare)*2/3 {
+		t.Errorf("expected the binary share to be much smaller than json: %v >= %v * 2 / 3", len(share), len(jsonShare))
+	}
+	text := EncodeShareText(share)
+	if !IsShareText(text) {
+		t.Errorf("expected share text: %v", text)
+	}
+
+	// binary, text typed in lower case, and json all decode to the same peerConfig
+	for _, body := range [][]byte{share, []byte(strings.ToLower(text)), jsonShare} {
+		decoded, err := decodePeerConfigShare(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body[0] == '{' {
+			decoded.Item, decoded.TotalItems = 1, 2
+		}
+		if !reflect.DeepEqual(decoded, config) {
+			t.Errorf("decoded peerConfig does not match:\n%+v\n%+v", decoded, config)
+		}
+	}
+
+	list, err := encodePeerConfigListShare([]peerConfig{config, config})
+	if err != nil {
+		t.Fatal(err)
+	}
+	configs, err := decodePeerConfigListShare([]byte(EncodeShareText(list)))
+	if err != nil || len(configs) != 2 || !reflect.DeepEqual(configs[1], config) {
+		t.Errorf("decoded peerConfig list does not match: %v", err)
+	}
+	if _, err := decodePeerConfigListShare(share); err == nil {
+		t.Error("expected a single peerConfig to be rejected as a list")
+	}
+
+	mistyped := []byte(text)
+	i := len(mistyped) / 2
+	if mistyped[i] == 'A' {
+		mistyped[i] = 'B'
+	} else if mistyped[i] != '-' {
+		mistyped[i] = 'A'
+	} else {
+		mistyped[i+1] = '2'
+	}
+	corrupt := map[string][]byte{
+		"mistyped text":  mistyped,
+		"truncated text": []byte(text[:len(text)-7]),
+		"bad character":  []byte(text[:10] + "!" + text[11:]),
+		"version":        append([]byte{9}, share[1:]...),
+		"truncated":      share[:len(share)-3],
+		"json":           jsonShare[:len(jsonShare)-3],
+		"json entropy":   []byte(`{"entropy": "!!"}`),
+	}
+	for name, body := range corrupt {
+		_, err := decodePeerConfigShare(body)
+		if _, ok := err.(ShareError); !ok {
+			t.Errorf("%v: expected ShareError, got %v", name, err)
+		}
+	}
+
+	// a field length that overruns the share is reported at its offset, even with a valid checksum
+	overrun := append([]byte{}, share[:len(share)-shareChecksumLength]...)
+	overrun[3] = 0xff
+	_, err = decodePeerConfigShare(encodeShare(peerConfigShare, overrun[2:]))
+	if e, ok := err.(ShareError); !ok || e.Field != "" || e.Offset != 3 {
+		t.Errorf("expected ShareError at byte 3, got %#v", err)
+	}
+}
@@ -10,6 +10,8 @@ type strategy struct {
 	Rendezvous storage.Storage
 	Storage    storage.Storage
 	Cipher     cipher
+	LookupKDF  KDFParams
+	Padding    PaddingScheme
 }
 
 // strategyPeerConfig is a a struct that encapsulates the shared strategy settings for handshake
@@ -17,6 +19,8 @@ type strategyPeerConfig struct {
 	Rendezvous storage.PeerStorage `json:"rendezvous"`
 	Storage    storage.PeerStorage `json:"storage"`
 	Cipher     peerCipher          `json:"cipher"`
+	LookupKDF  *KDFParams          `json:"lookup_kdf,omitempty"`
+	Padding    *PaddingScheme      `json:"padding,omitempty"`
 }
 
 // strategyConfig is a struct that encapsulates internal chat strategy settings
@@ -24,6 +28,8 @@ type strategyConfig struct {
 	Rendezvous storage.Config
 	Storage    storage.Config
 	Cipher     cipherConfig
+	LookupKDF  KDFParams
+	Padding    PaddingScheme
 }
 
 // Share returns the strategyPeerConfig for the strategy
@@ -37,6 +43,12 @@ func (s strategy) Share() (config strategyPeerConfig, err error) {
 	if config.Cipher, err = s.Cipher.share(); err != nil {
 		return
 	}
+	if s.LookupKDF.Version != LegacyKDF {
+		params := s.LookupKDF
+		config.LookupKDF = &params
+	}
+	padding := s.Padding
+	config.Padding = &padding
 	return
 }
 
@@ -51,6 +63,8 @@ func (s strategy) Export() (config strategyConfig, err error) {
 	if config.Cipher, err = s.Cipher.export(); err != nil {
 		return
 	}
+	config.LookupKDF = s.LookupKDF
+	config.Padding = s.Padding
 	return
 }
 
@@ -64,6 +78,19 @@ func strategyFromPeerConfig(config strategyPeerConfig) (s strategy, err error) {
 	if s.Cipher, err = newCipherFromPeer(config.Cipher); err != nil {
 		return
 	}
+	// peers that don't share lookup KDF parameters derive lookups with the legacy parameters
+	if config.LookupKDF != nil {
+		s.LookupKDF = *config.LookupKDF
+	}
+	if err = s.LookupKDF.Validate(); err != nil {
+		return
+	}
+	// peers that don't share a padding scheme are assumed to use the default
+	s.Padding = DefaultPaddingScheme
+	if config.Padding != nil {
+		s.Padding = *config.Padding
+	}
+	_, err = pad(s.Padding, []byte{})
 	return
 }
 
@@ -77,6 +104,12 @@ func strategyFromConfig(config strategyConfig) (s strategy, err error) {
 	if s.Cipher, err = newCipherFromConfig(config.Cipher); err != nil {
 		return
 	}
+	s.LookupKDF = config.LookupKDF
+	if err = s.LookupKDF.Validate(); err != nil {
+		return
+	}
+	s.Padding = config.Padding
+	_, err = pad(s.Padding, []byte{})
 	return
 }
 
@@ -94,5 +127,19 @@ func newDefaultStrategy() strategy {
 		Rendezvous: storage.NewDefaultRendezvous(),
 		Storage:    storage.NewDefaultMessageStorage(),
 		Cipher:     newDefaultCipher(),
+		LookupKDF:  DefaultKDFParams,
+		Padding:    DefaultPaddingScheme,
+	}
+}
+
+// newDefaultStrategyWithCipher takes a CipherType and returns the default strategy using a cipher of that
+// type and an error
+func newDefaultStrategyWithCipher(t CipherType) (strategy, error) {
+	c, err := newCipher(t)
+	if err != nil {
+		return strategy{}, err
 	}
+	s := newDefaultStrategy()
+	s.Cipher = c
+	return s, nil
 }
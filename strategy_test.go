@@ -66,3 +66,15 @@ func TestExportStrategy(t *testing.T) {
 	}
 	t.Log(string(stratJSON))
 }
+
+func TestStrategyFromPeerConfigUnknownEngine(t *testing.T) {
+	config, err := newDefaultStrategy().Share()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Storage.Type = storage.CustomEngine + 99
+	_, err = strategyFromPeerConfig(config)
+	if _, ok := err.(storage.UnknownEngineError); !ok {
+		t.Errorf("expected storage.UnknownEngineError, got %v", err)
+	}
+}